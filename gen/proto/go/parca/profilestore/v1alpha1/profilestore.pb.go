@@ -0,0 +1,62 @@
+// Copyright 2021 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Hand-maintained mirror of proto/parca/profilestore/v1alpha1/profilestore.proto.
+// protoc/buf isn't wired into this tree yet, so these types are kept in
+// sync with the .proto source by hand; update both together, and replace
+// this file with real protoc-gen-go output once codegen is set up.
+// source: parca/profilestore/v1alpha1/profilestore.proto
+
+package profilestorev1alpha1
+
+import "fmt"
+
+// Label is a single key/value label pair attached to a profile series.
+type Label struct {
+	Name  string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Value string `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+}
+
+func (x *Label) Reset()         { *x = Label{} }
+func (x *Label) String() string { return fmt.Sprintf("%+v", *x) }
+func (*Label) ProtoMessage()    {}
+
+func (x *Label) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Label) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+// LabelSet is the set of labels that identifies a profile series.
+type LabelSet struct {
+	Labels []*Label `protobuf:"bytes,1,rep,name=labels,proto3" json:"labels,omitempty"`
+}
+
+func (x *LabelSet) Reset()         { *x = LabelSet{} }
+func (x *LabelSet) String() string { return fmt.Sprintf("%+v", *x) }
+func (*LabelSet) ProtoMessage()    {}
+
+func (x *LabelSet) GetLabels() []*Label {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
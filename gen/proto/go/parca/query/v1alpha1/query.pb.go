@@ -0,0 +1,547 @@
+// Copyright 2021 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Hand-maintained mirror of proto/parca/query/v1alpha1/query.proto.
+// protoc/buf isn't wired into this tree yet, so these types are kept in
+// sync with the .proto source by hand; update both together, and replace
+// this file with real protoc-gen-go output once codegen is set up.
+// source: parca/query/v1alpha1/query.proto
+
+package queryv1alpha1
+
+import (
+	"fmt"
+
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+
+	profilestorev1alpha1 "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+)
+
+// QueryRequest_Mode selects how a single Query call should be interpreted:
+// a single profile, a time-range merge, or a diff between two selections.
+type QueryRequest_Mode int32
+
+const (
+	QueryRequest_MODE_SINGLE_UNSPECIFIED QueryRequest_Mode = 0
+	QueryRequest_MODE_MERGE              QueryRequest_Mode = 1
+	QueryRequest_MODE_DIFF               QueryRequest_Mode = 2
+)
+
+func (x QueryRequest_Mode) Enum() *QueryRequest_Mode {
+	return &x
+}
+
+// QueryRequest_ReportType selects the shape of the report returned for a
+// Query call.
+type QueryRequest_ReportType int32
+
+const (
+	QueryRequest_REPORT_TYPE_FLAMEGRAPH_UNSPECIFIED QueryRequest_ReportType = 0
+	QueryRequest_REPORT_TYPE_PPROF                  QueryRequest_ReportType = 1
+	QueryRequest_REPORT_TYPE_TOP                    QueryRequest_ReportType = 2
+)
+
+func (x QueryRequest_ReportType) Enum() *QueryRequest_ReportType {
+	return &x
+}
+
+// ProfileDiffSelection_Mode selects how one side of a diff is resolved.
+type ProfileDiffSelection_Mode int32
+
+const (
+	ProfileDiffSelection_MODE_SINGLE_UNSPECIFIED ProfileDiffSelection_Mode = 0
+	ProfileDiffSelection_MODE_MERGE              ProfileDiffSelection_Mode = 1
+)
+
+func (x ProfileDiffSelection_Mode) Enum() *ProfileDiffSelection_Mode {
+	return &x
+}
+
+// SingleProfile selects exactly one stored profile by query and timestamp.
+type SingleProfile struct {
+	Query string                 `protobuf:"bytes,1,opt,name=query,proto3" json:"query,omitempty"`
+	Time  *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=time,proto3" json:"time,omitempty"`
+}
+
+func (x *SingleProfile) Reset()         { *x = SingleProfile{} }
+func (x *SingleProfile) String() string { return fmt.Sprintf("%+v", *x) }
+func (*SingleProfile) ProtoMessage()    {}
+
+func (x *SingleProfile) GetQuery() string {
+	if x != nil {
+		return x.Query
+	}
+	return ""
+}
+
+func (x *SingleProfile) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+// MergeProfile selects every sample for a query within [Start, End] and
+// merges them into a single report.
+type MergeProfile struct {
+	Query string                 `protobuf:"bytes,1,opt,name=query,proto3" json:"query,omitempty"`
+	Start *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=start,proto3" json:"start,omitempty"`
+	End   *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=end,proto3" json:"end,omitempty"`
+}
+
+func (x *MergeProfile) Reset()         { *x = MergeProfile{} }
+func (x *MergeProfile) String() string { return fmt.Sprintf("%+v", *x) }
+func (*MergeProfile) ProtoMessage()    {}
+
+func (x *MergeProfile) GetQuery() string {
+	if x != nil {
+		return x.Query
+	}
+	return ""
+}
+
+func (x *MergeProfile) GetStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *MergeProfile) GetEnd() *timestamppb.Timestamp {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+// ProfileDiffSelection is one side ("A" or "B") of a DiffProfile request.
+type ProfileDiffSelection struct {
+	Mode    ProfileDiffSelection_Mode      `protobuf:"varint,1,opt,name=mode,proto3,enum=parca.query.v1alpha1.ProfileDiffSelection_Mode" json:"mode,omitempty"`
+	Options isProfileDiffSelection_Options `protobuf_oneof:"options"`
+}
+
+func (x *ProfileDiffSelection) Reset()         { *x = ProfileDiffSelection{} }
+func (x *ProfileDiffSelection) String() string { return fmt.Sprintf("%+v", *x) }
+func (*ProfileDiffSelection) ProtoMessage()    {}
+
+func (x *ProfileDiffSelection) GetMode() ProfileDiffSelection_Mode {
+	if x != nil {
+		return x.Mode
+	}
+	return ProfileDiffSelection_MODE_SINGLE_UNSPECIFIED
+}
+
+type isProfileDiffSelection_Options interface {
+	isProfileDiffSelection_Options()
+}
+
+type ProfileDiffSelection_Single struct {
+	Single *SingleProfile `protobuf:"bytes,2,opt,name=single,proto3,oneof"`
+}
+
+type ProfileDiffSelection_Merge struct {
+	Merge *MergeProfile `protobuf:"bytes,3,opt,name=merge,proto3,oneof"`
+}
+
+func (*ProfileDiffSelection_Single) isProfileDiffSelection_Options() {}
+func (*ProfileDiffSelection_Merge) isProfileDiffSelection_Options()  {}
+
+func (x *ProfileDiffSelection) GetSingle() *SingleProfile {
+	if x, ok := x.GetOptions().(*ProfileDiffSelection_Single); ok {
+		return x.Single
+	}
+	return nil
+}
+
+func (x *ProfileDiffSelection) GetMerge() *MergeProfile {
+	if x, ok := x.GetOptions().(*ProfileDiffSelection_Merge); ok {
+		return x.Merge
+	}
+	return nil
+}
+
+func (x *ProfileDiffSelection) GetOptions() isProfileDiffSelection_Options {
+	if x != nil {
+		return x.Options
+	}
+	return nil
+}
+
+// DiffProfile selects two profiles, A and B, to be diffed against
+// each other.
+type DiffProfile struct {
+	A *ProfileDiffSelection `protobuf:"bytes,1,opt,name=a,proto3" json:"a,omitempty"`
+	B *ProfileDiffSelection `protobuf:"bytes,2,opt,name=b,proto3" json:"b,omitempty"`
+}
+
+func (x *DiffProfile) Reset()         { *x = DiffProfile{} }
+func (x *DiffProfile) String() string { return fmt.Sprintf("%+v", *x) }
+func (*DiffProfile) ProtoMessage()    {}
+
+func (x *DiffProfile) GetA() *ProfileDiffSelection {
+	if x != nil {
+		return x.A
+	}
+	return nil
+}
+
+func (x *DiffProfile) GetB() *ProfileDiffSelection {
+	if x != nil {
+		return x.B
+	}
+	return nil
+}
+
+// QueryRequest is a single-profile flamegraph/pprof/top request, in one of
+// three modes: a single profile, a time-range merge, or a diff.
+type QueryRequest struct {
+	Mode       QueryRequest_Mode       `protobuf:"varint,1,opt,name=mode,proto3,enum=parca.query.v1alpha1.QueryRequest_Mode" json:"mode,omitempty"`
+	Options    isQueryRequest_Options  `protobuf_oneof:"options"`
+	ReportType QueryRequest_ReportType `protobuf:"varint,4,opt,name=report_type,json=reportType,proto3,enum=parca.query.v1alpha1.QueryRequest_ReportType" json:"report_type,omitempty"`
+}
+
+func (x *QueryRequest) Reset()         { *x = QueryRequest{} }
+func (x *QueryRequest) String() string { return fmt.Sprintf("%+v", *x) }
+func (*QueryRequest) ProtoMessage()    {}
+
+type isQueryRequest_Options interface {
+	isQueryRequest_Options()
+}
+
+type QueryRequest_Single struct {
+	Single *SingleProfile `protobuf:"bytes,2,opt,name=single,proto3,oneof"`
+}
+
+type QueryRequest_Merge struct {
+	Merge *MergeProfile `protobuf:"bytes,3,opt,name=merge,proto3,oneof"`
+}
+
+type QueryRequest_Diff struct {
+	Diff *DiffProfile `protobuf:"bytes,5,opt,name=diff,proto3,oneof"`
+}
+
+func (*QueryRequest_Single) isQueryRequest_Options() {}
+func (*QueryRequest_Merge) isQueryRequest_Options()  {}
+func (*QueryRequest_Diff) isQueryRequest_Options()   {}
+
+func (x *QueryRequest) GetOptions() isQueryRequest_Options {
+	if x != nil {
+		return x.Options
+	}
+	return nil
+}
+
+func (x *QueryRequest) GetMode() QueryRequest_Mode {
+	if x != nil {
+		return x.Mode
+	}
+	return QueryRequest_MODE_SINGLE_UNSPECIFIED
+}
+
+func (x *QueryRequest) GetReportType() QueryRequest_ReportType {
+	if x != nil {
+		return x.ReportType
+	}
+	return QueryRequest_REPORT_TYPE_FLAMEGRAPH_UNSPECIFIED
+}
+
+func (x *QueryRequest) GetSingle() *SingleProfile {
+	if x, ok := x.GetOptions().(*QueryRequest_Single); ok {
+		return x.Single
+	}
+	return nil
+}
+
+func (x *QueryRequest) GetMerge() *MergeProfile {
+	if x, ok := x.GetOptions().(*QueryRequest_Merge); ok {
+		return x.Merge
+	}
+	return nil
+}
+
+func (x *QueryRequest) GetDiff() *DiffProfile {
+	if x, ok := x.GetOptions().(*QueryRequest_Diff); ok {
+		return x.Diff
+	}
+	return nil
+}
+
+// FlamegraphNode is a single node of a flamegraph report.
+type FlamegraphNode struct {
+	Name       string            `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Cumulative int64             `protobuf:"varint,2,opt,name=cumulative,proto3" json:"cumulative,omitempty"`
+	Children   []*FlamegraphNode `protobuf:"bytes,3,rep,name=children,proto3" json:"children,omitempty"`
+}
+
+func (x *FlamegraphNode) Reset()         { *x = FlamegraphNode{} }
+func (x *FlamegraphNode) String() string { return fmt.Sprintf("%+v", *x) }
+func (*FlamegraphNode) ProtoMessage()    {}
+
+// Flamegraph is the root of a flamegraph report.
+type Flamegraph struct {
+	Root  *FlamegraphNode `protobuf:"bytes,1,opt,name=root,proto3" json:"root,omitempty"`
+	Total int64           `protobuf:"varint,2,opt,name=total,proto3" json:"total,omitempty"`
+}
+
+func (x *Flamegraph) Reset()         { *x = Flamegraph{} }
+func (x *Flamegraph) String() string { return fmt.Sprintf("%+v", *x) }
+func (*Flamegraph) ProtoMessage()    {}
+
+// QueryResponse carries the report requested by a QueryRequest. Only
+// Flamegraph is populated today; Pprof and Top reports will add their own
+// oneof members when REPORT_TYPE_PPROF/REPORT_TYPE_TOP are implemented.
+type QueryResponse struct {
+	Report isQueryResponse_Report `protobuf_oneof:"report"`
+}
+
+func (x *QueryResponse) Reset()         { *x = QueryResponse{} }
+func (x *QueryResponse) String() string { return fmt.Sprintf("%+v", *x) }
+func (*QueryResponse) ProtoMessage()    {}
+
+type isQueryResponse_Report interface {
+	isQueryResponse_Report()
+}
+
+type QueryResponse_Flamegraph struct {
+	Flamegraph *Flamegraph `protobuf:"bytes,1,opt,name=flamegraph,proto3,oneof"`
+}
+
+func (*QueryResponse_Flamegraph) isQueryResponse_Report() {}
+
+func (x *QueryResponse) GetFlamegraph() *Flamegraph {
+	if x != nil {
+		if f, ok := x.Report.(*QueryResponse_Flamegraph); ok {
+			return f.Flamegraph
+		}
+	}
+	return nil
+}
+
+// QueryRangeRequest requests the timeline of sample values for a query
+// across [Start, End], truncated to at most Limit series.
+type QueryRangeRequest struct {
+	Query string                 `protobuf:"bytes,1,opt,name=query,proto3" json:"query,omitempty"`
+	Start *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=start,proto3" json:"start,omitempty"`
+	End   *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=end,proto3" json:"end,omitempty"`
+	Limit uint32                 `protobuf:"varint,4,opt,name=limit,proto3" json:"limit,omitempty"`
+	// ResolutionHint overrides automatic resolution selection, forcing
+	// QueryRange to read from a specific downsample resolution ("5m", "1h"
+	// or "1d"). An empty string leaves the resolution to automatic
+	// selection; raw data cannot be forced through this field.
+	ResolutionHint string `protobuf:"bytes,5,opt,name=resolution_hint,json=resolutionHint,proto3" json:"resolution_hint,omitempty"`
+}
+
+func (x *QueryRangeRequest) Reset()         { *x = QueryRangeRequest{} }
+func (x *QueryRangeRequest) String() string { return fmt.Sprintf("%+v", *x) }
+func (*QueryRangeRequest) ProtoMessage()    {}
+
+func (x *QueryRangeRequest) GetQuery() string {
+	if x != nil {
+		return x.Query
+	}
+	return ""
+}
+
+func (x *QueryRangeRequest) GetStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *QueryRangeRequest) GetEnd() *timestamppb.Timestamp {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+func (x *QueryRangeRequest) GetLimit() uint32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *QueryRangeRequest) GetResolutionHint() string {
+	if x != nil {
+		return x.ResolutionHint
+	}
+	return ""
+}
+
+// SampleValue is a single (timestamp, value) point in a Series' timeline.
+type SampleValue struct {
+	Timestamp *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Value     int64                  `protobuf:"varint,2,opt,name=value,proto3" json:"value,omitempty"`
+}
+
+func (x *SampleValue) Reset()         { *x = SampleValue{} }
+func (x *SampleValue) String() string { return fmt.Sprintf("%+v", *x) }
+func (*SampleValue) ProtoMessage()    {}
+
+// Series is the timeline of sample values for a single label set.
+type Series struct {
+	Labelset *profilestorev1alpha1.LabelSet `protobuf:"bytes,1,opt,name=labelset,proto3" json:"labelset,omitempty"`
+	Samples  []*SampleValue                 `protobuf:"bytes,2,rep,name=samples,proto3" json:"samples,omitempty"`
+}
+
+func (x *Series) Reset()         { *x = Series{} }
+func (x *Series) String() string { return fmt.Sprintf("%+v", *x) }
+func (*Series) ProtoMessage()    {}
+
+// QueryRangeResponse is the response to a QueryRangeRequest: one Series per
+// distinct label set matched by Query.
+type QueryRangeResponse struct {
+	Series []*Series `protobuf:"bytes,1,rep,name=series,proto3" json:"series,omitempty"`
+}
+
+func (x *QueryRangeResponse) Reset()         { *x = QueryRangeResponse{} }
+func (x *QueryRangeResponse) String() string { return fmt.Sprintf("%+v", *x) }
+func (*QueryRangeResponse) ProtoMessage()    {}
+
+// LabelsRequest requests every label name present on series matching Match
+// within [Start, End]. An empty Match selects every series.
+type LabelsRequest struct {
+	Match []string               `protobuf:"bytes,1,rep,name=match,proto3" json:"match,omitempty"`
+	Start *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=start,proto3" json:"start,omitempty"`
+	End   *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=end,proto3" json:"end,omitempty"`
+}
+
+func (x *LabelsRequest) Reset()         { *x = LabelsRequest{} }
+func (x *LabelsRequest) String() string { return fmt.Sprintf("%+v", *x) }
+func (*LabelsRequest) ProtoMessage()    {}
+
+func (x *LabelsRequest) GetMatch() []string {
+	if x != nil {
+		return x.Match
+	}
+	return nil
+}
+
+func (x *LabelsRequest) GetStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *LabelsRequest) GetEnd() *timestamppb.Timestamp {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+// LabelsResponse is the sorted, deduplicated set of label names present on
+// the series a LabelsRequest matched.
+type LabelsResponse struct {
+	LabelNames []string `protobuf:"bytes,1,rep,name=label_names,json=labelNames,proto3" json:"label_names,omitempty"`
+}
+
+func (x *LabelsResponse) Reset()         { *x = LabelsResponse{} }
+func (x *LabelsResponse) String() string { return fmt.Sprintf("%+v", *x) }
+func (*LabelsResponse) ProtoMessage()    {}
+
+// ValuesRequest requests every value LabelName takes on series matching
+// Match within [Start, End].
+type ValuesRequest struct {
+	LabelName string                 `protobuf:"bytes,1,opt,name=label_name,json=labelName,proto3" json:"label_name,omitempty"`
+	Match     []string               `protobuf:"bytes,2,rep,name=match,proto3" json:"match,omitempty"`
+	Start     *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=start,proto3" json:"start,omitempty"`
+	End       *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=end,proto3" json:"end,omitempty"`
+}
+
+func (x *ValuesRequest) Reset()         { *x = ValuesRequest{} }
+func (x *ValuesRequest) String() string { return fmt.Sprintf("%+v", *x) }
+func (*ValuesRequest) ProtoMessage()    {}
+
+func (x *ValuesRequest) GetLabelName() string {
+	if x != nil {
+		return x.LabelName
+	}
+	return ""
+}
+
+func (x *ValuesRequest) GetMatch() []string {
+	if x != nil {
+		return x.Match
+	}
+	return nil
+}
+
+func (x *ValuesRequest) GetStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *ValuesRequest) GetEnd() *timestamppb.Timestamp {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+// ValuesResponse is the sorted, deduplicated set of values LabelName takes
+// on the series a ValuesRequest matched.
+type ValuesResponse struct {
+	LabelValues []string `protobuf:"bytes,1,rep,name=label_values,json=labelValues,proto3" json:"label_values,omitempty"`
+}
+
+func (x *ValuesResponse) Reset()         { *x = ValuesResponse{} }
+func (x *ValuesResponse) String() string { return fmt.Sprintf("%+v", *x) }
+func (*ValuesResponse) ProtoMessage()    {}
+
+// SeriesRequest requests the label set of every series matching Match
+// within [Start, End]. Match must contain at least one selector.
+type SeriesRequest struct {
+	Match []string               `protobuf:"bytes,1,rep,name=match,proto3" json:"match,omitempty"`
+	Start *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=start,proto3" json:"start,omitempty"`
+	End   *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=end,proto3" json:"end,omitempty"`
+}
+
+func (x *SeriesRequest) Reset()         { *x = SeriesRequest{} }
+func (x *SeriesRequest) String() string { return fmt.Sprintf("%+v", *x) }
+func (*SeriesRequest) ProtoMessage()    {}
+
+func (x *SeriesRequest) GetMatch() []string {
+	if x != nil {
+		return x.Match
+	}
+	return nil
+}
+
+func (x *SeriesRequest) GetStart() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Start
+	}
+	return nil
+}
+
+func (x *SeriesRequest) GetEnd() *timestamppb.Timestamp {
+	if x != nil {
+		return x.End
+	}
+	return nil
+}
+
+// SeriesResponse is the label set of every series a SeriesRequest matched.
+type SeriesResponse struct {
+	Series []*profilestorev1alpha1.LabelSet `protobuf:"bytes,1,rep,name=series,proto3" json:"series,omitempty"`
+}
+
+func (x *SeriesResponse) Reset()         { *x = SeriesResponse{} }
+func (x *SeriesResponse) String() string { return fmt.Sprintf("%+v", *x) }
+func (*SeriesResponse) ProtoMessage()    {}
@@ -0,0 +1,30 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cache provides the result cache that fronts pkg/query's Query and
+// QueryRange methods, following the same Cache abstraction Grafana Mimir's
+// query frontend uses: callers batch lookups and stores by key, and a
+// backend is free to drop entries (a miss is always a valid answer).
+package cache
+
+import "time"
+
+// Cache stores serialized query responses, keyed by a caller-chosen string
+// that encodes the request that produced them. Implementations may evict or
+// expire entries at will; a Get miss is never an error.
+type Cache interface {
+	// Get returns the cached value for key, or ok=false on a miss.
+	Get(key string) (value []byte, ok bool)
+	// Store writes every entry, each expiring after ttl.
+	Store(entries map[string][]byte, ttl time.Duration)
+}
@@ -0,0 +1,68 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import "fmt"
+
+// Backend selects which Cache implementation Config.New builds.
+type Backend string
+
+const (
+	BackendInMemory  Backend = "in-memory"
+	BackendMemcached Backend = "memcached"
+	BackendRedis     Backend = "redis"
+)
+
+// Config is the flag group a command wires up with kingpin (or any other
+// flag library) to let operators pick and size a cache backend, mirroring
+// how Thanos' --store.caching-bucket.config flag group is structured.
+type Config struct {
+	Backend Backend
+
+	// InMemoryMaxItems bounds the in-process LRU; only used when
+	// Backend == BackendInMemory.
+	InMemoryMaxItems int
+
+	// MemcachedAddresses are the "host:port" addresses of the memcached
+	// servers to use; only used when Backend == BackendMemcached.
+	MemcachedAddresses []string
+
+	// RedisAddress is the "host:port" address of the Redis instance to use;
+	// only used when Backend == BackendRedis.
+	RedisAddress string
+}
+
+// New builds the Cache described by cfg.
+func (cfg Config) New() (Cache, error) {
+	switch cfg.Backend {
+	case "", BackendInMemory:
+		maxItems := cfg.InMemoryMaxItems
+		if maxItems <= 0 {
+			maxItems = 1024
+		}
+		return NewInMemoryCache(maxItems), nil
+	case BackendMemcached:
+		if len(cfg.MemcachedAddresses) == 0 {
+			return nil, fmt.Errorf("cache backend %q requires at least one address", cfg.Backend)
+		}
+		return NewMemcachedCache(cfg.MemcachedAddresses...), nil
+	case BackendRedis:
+		if cfg.RedisAddress == "" {
+			return nil, fmt.Errorf("cache backend %q requires an address", cfg.Backend)
+		}
+		return NewRedisCache(cfg.RedisAddress), nil
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", cfg.Backend)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type inMemoryEntry struct {
+	key    string
+	value  []byte
+	expiry time.Time
+}
+
+// InMemoryCache is a Cache backed by a bounded, in-process LRU. It's the
+// default cache backend: no extra infrastructure to run, at the cost of not
+// being shared across Parca replicas.
+type InMemoryCache struct {
+	maxItems int
+
+	mtx   sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+// NewInMemoryCache returns an InMemoryCache holding at most maxItems
+// entries, evicting the least recently used entry once full. A maxItems
+// of zero or less leaves the cache unbounded.
+func NewInMemoryCache(maxItems int) *InMemoryCache {
+	return &InMemoryCache{
+		maxItems: maxItems,
+		ll:       list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+func (c *InMemoryCache) Get(key string) ([]byte, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*inMemoryEntry)
+	if time.Now().After(e.expiry) {
+		c.removeElement(el)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return e.value, true
+}
+
+func (c *InMemoryCache) Store(entries map[string][]byte, ttl time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	expiry := time.Now().Add(ttl)
+	for k, v := range entries {
+		if el, ok := c.items[k]; ok {
+			el.Value.(*inMemoryEntry).value = v
+			el.Value.(*inMemoryEntry).expiry = expiry
+			c.ll.MoveToFront(el)
+			continue
+		}
+
+		el := c.ll.PushFront(&inMemoryEntry{key: k, value: v, expiry: expiry})
+		c.items[k] = el
+		if c.maxItems > 0 && c.ll.Len() > c.maxItems {
+			if oldest := c.ll.Back(); oldest != nil {
+				c.removeElement(oldest)
+			}
+		}
+	}
+}
+
+func (c *InMemoryCache) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*inMemoryEntry).key)
+}
@@ -0,0 +1,49 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// MemcachedCache is a Cache backed by a memcached cluster, shared across
+// every Parca replica pointed at the same servers.
+type MemcachedCache struct {
+	client *memcache.Client
+}
+
+// NewMemcachedCache returns a MemcachedCache talking to the given
+// "host:port" addresses.
+func NewMemcachedCache(addrs ...string) *MemcachedCache {
+	return &MemcachedCache{client: memcache.New(addrs...)}
+}
+
+func (c *MemcachedCache) Get(key string) ([]byte, bool) {
+	item, err := c.client.Get(key)
+	if err != nil {
+		return nil, false
+	}
+	return item.Value, true
+}
+
+func (c *MemcachedCache) Store(entries map[string][]byte, ttl time.Duration) {
+	expiration := int32(ttl.Seconds())
+	for k, v := range entries {
+		// Errors are intentionally swallowed: a failed Store degrades to a
+		// cache miss next time, which is always a correct (if slower) answer.
+		_ = c.client.Set(&memcache.Item{Key: k, Value: v, Expiration: expiration})
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisCache is a Cache backed by a single Redis instance or cluster,
+// shared across every Parca replica pointed at the same address.
+type RedisCache struct {
+	client *redis.Client
+}
+
+// NewRedisCache returns a RedisCache talking to the Redis instance at addr.
+func NewRedisCache(addr string) *RedisCache {
+	return &RedisCache{client: redis.NewClient(&redis.Options{Addr: addr})}
+}
+
+func (c *RedisCache) Get(key string) ([]byte, bool) {
+	b, err := c.client.Get(context.Background(), key).Bytes()
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+func (c *RedisCache) Store(entries map[string][]byte, ttl time.Duration) {
+	ctx := context.Background()
+	for k, v := range entries {
+		// Errors are intentionally swallowed: a failed Store degrades to a
+		// cache miss next time, which is always a correct (if slower) answer.
+		_ = c.client.Set(ctx, k, v, ttl).Err()
+	}
+}
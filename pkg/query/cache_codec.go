@@ -0,0 +1,93 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
+)
+
+// encodeQueryRangeResponse and decodeQueryRangeResponse (de)serialize a
+// QueryRangeResponse as protobuf wire format for storage in the result
+// cache, so entries written by one version stay readable by another rather
+// than failing to decode (or silently decoding wrong) across schema
+// evolution, the way a gob-encoded cache entry could.
+func encodeQueryRangeResponse(resp *pb.QueryRangeResponse) ([]byte, error) {
+	b, err := proto.Marshal(resp)
+	if err != nil {
+		return nil, fmt.Errorf("encode cached query range response: %w", err)
+	}
+	return b, nil
+}
+
+func decodeQueryRangeResponse(b []byte) (*pb.QueryRangeResponse, error) {
+	var resp pb.QueryRangeResponse
+	if err := proto.Unmarshal(b, &resp); err != nil {
+		return nil, fmt.Errorf("decode cached query range response: %w", err)
+	}
+	return &resp, nil
+}
+
+// encodeQueryResponse and decodeQueryResponse (de)serialize a QueryResponse
+// (flamegraph report) as protobuf wire format for storage in the result
+// cache.
+func encodeQueryResponse(resp *pb.QueryResponse) ([]byte, error) {
+	b, err := proto.Marshal(resp.GetFlamegraph())
+	if err != nil {
+		return nil, fmt.Errorf("encode cached query response: %w", err)
+	}
+	return b, nil
+}
+
+func decodeQueryResponse(b []byte) (*pb.QueryResponse, error) {
+	var fg pb.Flamegraph
+	if err := proto.Unmarshal(b, &fg); err != nil {
+		return nil, fmt.Errorf("decode cached query response: %w", err)
+	}
+	return &pb.QueryResponse{Report: &pb.QueryResponse_Flamegraph{Flamegraph: &fg}}, nil
+}
+
+// queryCacheKey builds a stable cache key for a QueryRequest, covering every
+// field relevant to the mode it's in.
+func queryCacheKey(req *pb.QueryRequest) string {
+	switch req.GetMode() {
+	case pb.QueryRequest_MODE_SINGLE_UNSPECIFIED:
+		single := req.GetSingle()
+		return fmt.Sprintf("query:single:%d:%s:%d", req.GetReportType(), single.GetQuery(), single.GetTime().AsTime().UnixMilli())
+	case pb.QueryRequest_MODE_MERGE:
+		merge := req.GetMerge()
+		return fmt.Sprintf("query:merge:%d:%s:%d:%d", req.GetReportType(), merge.GetQuery(), merge.GetStart().AsTime().UnixMilli(), merge.GetEnd().AsTime().UnixMilli())
+	case pb.QueryRequest_MODE_DIFF:
+		diff := req.GetDiff()
+		return fmt.Sprintf("query:diff:%d:%s:%s", req.GetReportType(), selectionCacheKey(diff.GetA()), selectionCacheKey(diff.GetB()))
+	default:
+		return fmt.Sprintf("query:unknown:%d", req.GetMode())
+	}
+}
+
+func selectionCacheKey(sel *pb.ProfileDiffSelection) string {
+	switch sel.GetMode() {
+	case pb.ProfileDiffSelection_MODE_SINGLE_UNSPECIFIED:
+		single := sel.GetSingle()
+		return fmt.Sprintf("single:%s:%d", single.GetQuery(), single.GetTime().AsTime().UnixMilli())
+	case pb.ProfileDiffSelection_MODE_MERGE:
+		merge := sel.GetMerge()
+		return fmt.Sprintf("merge:%s:%d:%d", merge.GetQuery(), merge.GetStart().AsTime().UnixMilli(), merge.GetEnd().AsTime().UnixMilli())
+	default:
+		return fmt.Sprintf("unknown:%d", sel.GetMode())
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
+	"github.com/parca-dev/parca/pkg/query/cache"
+	"github.com/parca-dev/parca/pkg/storage"
+	"github.com/parca-dev/parca/pkg/storage/metastore"
+)
+
+func Test_QueryRange_CacheStats(t *testing.T) {
+	ctx := context.Background()
+	db := storage.OpenDB(prometheus.NewRegistry())
+	s, err := metastore.NewInMemoryProfileMetaStore("queryrangecache")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		s.Close()
+	})
+
+	reg := prometheus.NewRegistry()
+	q := New(nil, db, s, WithCache(cache.NewInMemoryCache(64), reg))
+
+	app, err := db.Appender(ctx, labels.Labels{
+		labels.Label{Name: "__name__", Value: "allocs"},
+	})
+	require.NoError(t, err)
+
+	f, err := os.Open("testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+	p, err := profile.Parse(f)
+	require.NoError(t, err)
+
+	// Put the profile in the past, so the chunk it falls into is immutable
+	// and therefore cacheable.
+	p.TimeNanos = time.Now().Add(-2 * time.Hour).UnixNano()
+	require.NoError(t, app.Append(storage.ProfileFromPprof(nil, s, p, 0)))
+
+	req := &pb.QueryRangeRequest{
+		Query: "allocs",
+		Start: timestamppb.New(time.Now().Add(-3 * time.Hour)),
+		End:   timestamppb.New(time.Now().Add(-1 * time.Hour)),
+		Limit: 10,
+	}
+
+	_, err = q.QueryRange(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, float64(0), testutil.ToFloat64(q.cacheHits))
+
+	_, err = q.QueryRange(ctx, req)
+	require.NoError(t, err)
+	require.Greater(t, testutil.ToFloat64(q.cacheHits), float64(0))
+}
+
+func Test_Query_CacheStats(t *testing.T) {
+	ctx := context.Background()
+	db := storage.OpenDB(prometheus.NewRegistry())
+	s, err := metastore.NewInMemoryProfileMetaStore("querycache")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		s.Close()
+	})
+
+	reg := prometheus.NewRegistry()
+	q := New(nil, db, s, WithCache(cache.NewInMemoryCache(64), reg))
+
+	app, err := db.Appender(ctx, labels.Labels{
+		labels.Label{Name: "__name__", Value: "allocs"},
+	})
+	require.NoError(t, err)
+
+	f, err := os.Open("../storage/testdata/profile1.pb.gz")
+	require.NoError(t, err)
+	p1, err := profile.Parse(f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	t1 := (time.Now().UnixNano() / 1000000) * 1000000
+	p1.TimeNanos = t1
+	require.NoError(t, app.Append(storage.ProfileFromPprof(nil, s, p1, 0)))
+
+	req := &pb.QueryRequest{
+		Mode: pb.QueryRequest_MODE_SINGLE_UNSPECIFIED,
+		Options: &pb.QueryRequest_Single{
+			Single: &pb.SingleProfile{
+				Query: "allocs",
+				Time:  timestamppb.New(time.Unix(0, t1)),
+			},
+		},
+		ReportType: pb.QueryRequest_REPORT_TYPE_FLAMEGRAPH_UNSPECIFIED,
+	}
+
+	_, err = q.Query(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, float64(0), testutil.ToFloat64(q.cacheHits))
+
+	_, err = q.Query(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, float64(1), testutil.ToFloat64(q.cacheHits))
+}
@@ -0,0 +1,125 @@
+// Copyright 2021 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
+	"github.com/parca-dev/parca/pkg/storage"
+)
+
+// flamegraphNode is the mutable tree parca builds up while merging samples,
+// before it's flattened into the pb.FlamegraphNode wire format.
+type flamegraphNode struct {
+	name     string
+	value    int64
+	children map[string]*flamegraphNode
+	order    []string
+}
+
+func newFlamegraphNode(name string) *flamegraphNode {
+	return &flamegraphNode{name: name, children: map[string]*flamegraphNode{}}
+}
+
+func (n *flamegraphNode) childFor(name string) *flamegraphNode {
+	c, ok := n.children[name]
+	if !ok {
+		c = newFlamegraphNode(name)
+		n.children[name] = c
+		n.order = append(n.order, name)
+	}
+	return c
+}
+
+func (n *flamegraphNode) toPB() *pb.FlamegraphNode {
+	out := &pb.FlamegraphNode{Name: n.name, Cumulative: n.value}
+	for _, name := range n.order {
+		out.Children = append(out.Children, n.children[name].toPB())
+	}
+	return out
+}
+
+// buildFlamegraphTree merges samples into a single root-first call tree,
+// summing the value of every sample along its stack's path.
+func buildFlamegraphTree(samples []*storage.Sample) *flamegraphNode {
+	root := newFlamegraphNode("root")
+	for _, s := range samples {
+		cur := root
+		cur.value += s.Value
+		// pprof orders locations leaf-first; walk it in reverse to build the
+		// tree root-first, the order a flamegraph is rendered in.
+		for i := len(s.Stack) - 1; i >= 0; i-- {
+			cur = cur.childFor(s.Stack[i])
+			cur.value += s.Value
+		}
+	}
+	return root
+}
+
+// flamegraphFromSamples merges samples (typically all samples for a single
+// profile, or all samples across a time range for a merge query) into a
+// single Flamegraph report.
+func flamegraphFromSamples(samples []*storage.Sample) *pb.Flamegraph {
+	root := buildFlamegraphTree(samples)
+	return &pb.Flamegraph{Root: root.toPB(), Total: root.value}
+}
+
+// diffFlamegraphNode merges two node pairs into a single node whose value is
+// b's value minus a's, recursing over the union of both sides' children.
+func diffFlamegraphNode(name string, a, b *flamegraphNode) *flamegraphNode {
+	out := newFlamegraphNode(name)
+	if a != nil {
+		out.value -= a.value
+	}
+	if b != nil {
+		out.value += b.value
+	}
+
+	seen := map[string]struct{}{}
+	addChildren := func(n *flamegraphNode) {
+		if n == nil {
+			return
+		}
+		for _, name := range n.order {
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+
+			var childA, childB *flamegraphNode
+			if a != nil {
+				childA = a.children[name]
+			}
+			if b != nil {
+				childB = b.children[name]
+			}
+			child := diffFlamegraphNode(name, childA, childB)
+			out.children[name] = child
+			out.order = append(out.order, name)
+		}
+	}
+	addChildren(a)
+	addChildren(b)
+
+	return out
+}
+
+// flamegraphDiff builds a Flamegraph whose cumulative values are the
+// difference (b - a) between two sets of samples, keeping the union of both
+// sides' call trees so frames unique to either side are still visible.
+func flamegraphDiff(a, b []*storage.Sample) *pb.Flamegraph {
+	treeA := buildFlamegraphTree(a)
+	treeB := buildFlamegraphTree(b)
+	root := diffFlamegraphNode("root", treeA, treeB)
+	return &pb.Flamegraph{Root: root.toPB(), Total: root.value}
+}
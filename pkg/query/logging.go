@@ -0,0 +1,106 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
+)
+
+// seriesLogSampleRate is the fraction of logSeriesSampled calls that are
+// actually emitted at DEBUG, so a wide query iterating thousands of series
+// doesn't flood the log.
+const seriesLogSampleRate = 0.01
+
+// logQueryRangeResult emits a single structured INFO line summarizing a
+// QueryRange request, when WithLogQueries is set.
+func (q *Query) logQueryRangeResult(query string, start, end time.Time, resp *pb.QueryRangeResponse, cacheHit bool, started time.Time) {
+	if !q.logQueries {
+		return
+	}
+
+	var samplesScanned int
+	for _, s := range resp.GetSeries() {
+		samplesScanned += len(s.GetSamples())
+	}
+
+	q.logger.Info("query range",
+		"query", query,
+		"range_start", start,
+		"range_end", end,
+		"series_scanned", len(resp.GetSeries()),
+		"samples_scanned", samplesScanned,
+		"duration_ms", time.Since(started).Milliseconds(),
+		"cache_hit", cacheHit,
+	)
+}
+
+// logQueryResult emits a single structured INFO line summarizing a Query
+// request, when WithLogQueries is set.
+func (q *Query) logQueryResult(req *pb.QueryRequest, stats queryStats, cacheHit bool, started time.Time) {
+	if !q.logQueries {
+		return
+	}
+
+	q.logger.Info("query",
+		"query", requestQuery(req),
+		"mode", req.GetMode(),
+		"report_type", req.GetReportType(),
+		"series_scanned", stats.SeriesScanned,
+		"samples_scanned", stats.SamplesScanned,
+		"duration_ms", time.Since(started).Milliseconds(),
+		"cache_hit", cacheHit,
+	)
+}
+
+// logSeriesSampled emits a sampling-rate-limited DEBUG line for a single
+// series visited while iterating a query, when WithLogQueries is set.
+func (q *Query) logSeriesSampled(lset labels.Labels, numProfiles int) {
+	if !q.logQueries || rand.Float64() > seriesLogSampleRate {
+		return
+	}
+	q.logger.Debug("series sampled", "labels", lset.String(), "profiles", numProfiles)
+}
+
+// requestQuery returns the PromQL-like query string driving req, regardless
+// of which mode it's in, for logging purposes.
+func requestQuery(req *pb.QueryRequest) string {
+	switch opts := req.GetOptions().(type) {
+	case *pb.QueryRequest_Single:
+		return opts.Single.GetQuery()
+	case *pb.QueryRequest_Merge:
+		return opts.Merge.GetQuery()
+	case *pb.QueryRequest_Diff:
+		return "diff(" + requestSelectionQuery(opts.Diff.GetA()) + ", " + requestSelectionQuery(opts.Diff.GetB()) + ")"
+	default:
+		return ""
+	}
+}
+
+func requestSelectionQuery(sel *pb.ProfileDiffSelection) string {
+	if sel == nil {
+		return ""
+	}
+	if single := sel.GetSingle(); single != nil {
+		return single.GetQuery()
+	}
+	if merge := sel.GetMerge(); merge != nil {
+		return merge.GetQuery()
+	}
+	return ""
+}
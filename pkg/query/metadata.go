@@ -0,0 +1,174 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
+	"github.com/parca-dev/parca/pkg/storage"
+	"github.com/parca-dev/parca/pkg/storage/downsample"
+)
+
+// rawOnlyMatcher excludes series carrying downsample.ResolutionLabel, so
+// Labels, Values and Series only ever see raw series. Downsampled series
+// are an internal storage detail of QueryRange's resolution selection, not
+// something callers like the UI's autocomplete should have to know to
+// filter out themselves.
+var rawOnlyMatcher = labels.MustNewMatcher(labels.MatchEqual, downsample.ResolutionLabel, string(downsample.ResolutionRaw))
+
+// Labels returns every label name present on series matching req within
+// [Start, End], read directly off the storage index without iterating any
+// samples.
+func (q *Query) Labels(ctx context.Context, req *pb.LabelsRequest) (*pb.LabelsResponse, error) {
+	start, end, err := q.validateRange(req.GetStart(), req.GetEnd())
+	if err != nil {
+		return nil, err
+	}
+
+	series, err := q.seriesMatchingAny(ctx, req.GetMatch(), start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]struct{}{}
+	for _, s := range series {
+		for _, l := range s.Lset {
+			names[l.Name] = struct{}{}
+		}
+	}
+
+	out := make([]string, 0, len(names))
+	for n := range names {
+		out = append(out, n)
+	}
+	sort.Strings(out)
+	return &pb.LabelsResponse{LabelNames: out}, nil
+}
+
+// Values returns every value req's label name takes on series matching req
+// within [Start, End].
+func (q *Query) Values(ctx context.Context, req *pb.ValuesRequest) (*pb.ValuesResponse, error) {
+	if req.GetLabelName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "label name must not be empty")
+	}
+
+	start, end, err := q.validateRange(req.GetStart(), req.GetEnd())
+	if err != nil {
+		return nil, err
+	}
+
+	series, err := q.seriesMatchingAny(ctx, req.GetMatch(), start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]struct{}{}
+	for _, s := range series {
+		if v := s.Lset.Get(req.GetLabelName()); v != "" {
+			values[v] = struct{}{}
+		}
+	}
+
+	out := make([]string, 0, len(values))
+	for v := range values {
+		out = append(out, v)
+	}
+	sort.Strings(out)
+	return &pb.ValuesResponse{LabelValues: out}, nil
+}
+
+// Series returns the label set of every series matching req within [Start,
+// End]. Unlike Labels and Values, at least one match selector is required,
+// mirroring Prometheus' own series API.
+func (q *Query) Series(ctx context.Context, req *pb.SeriesRequest) (*pb.SeriesResponse, error) {
+	if len(req.GetMatch()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "match must contain at least one selector")
+	}
+
+	start, end, err := q.validateRange(req.GetStart(), req.GetEnd())
+	if err != nil {
+		return nil, err
+	}
+
+	series, err := q.seriesMatchingAny(ctx, req.GetMatch(), start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &pb.SeriesResponse{Series: make([]*profilestorepb.LabelSet, 0, len(series))}
+	for _, s := range series {
+		out.Series = append(out.Series, labelSetToPB(s.Lset))
+	}
+	return out, nil
+}
+
+// validateRange applies the Start/End validation shared by Labels, Values
+// and Series.
+func (q *Query) validateRange(start, end *timestamppb.Timestamp) (time.Time, time.Time, error) {
+	if start == nil || end == nil {
+		return time.Time{}, time.Time{}, status.Error(codes.InvalidArgument, "start and end must be specified")
+	}
+	s, e := start.AsTime(), end.AsTime()
+	if e.Before(s) {
+		return time.Time{}, time.Time{}, status.Error(codes.InvalidArgument, "end must be after start")
+	}
+	return s, e, nil
+}
+
+// seriesMatchingAny returns the union of series matched by each selector in
+// match (an empty match selects every series), restricted to series with at
+// least one profile in [start, end].
+func (q *Query) seriesMatchingAny(ctx context.Context, match []string, start, end time.Time) ([]*storage.MemSeries, error) {
+	mint, maxt := start.UnixMilli(), end.UnixMilli()
+
+	seen := map[*storage.MemSeries]struct{}{}
+	var result []*storage.MemSeries
+
+	add := func(matchers []*labels.Matcher) {
+		matchers = append(matchers, rawOnlyMatcher)
+		for _, s := range q.db.Select(ctx, matchers...) {
+			if _, ok := seen[s]; ok {
+				continue
+			}
+			if len(s.Profiles(mint, maxt)) == 0 {
+				continue
+			}
+			seen[s] = struct{}{}
+			result = append(result, s)
+		}
+	}
+
+	if len(match) == 0 {
+		add(nil)
+		return result, nil
+	}
+
+	for _, m := range match {
+		matchers, err := parseQuery(m)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		add(matchers)
+	}
+	return result, nil
+}
@@ -0,0 +1,161 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
+	"github.com/parca-dev/parca/pkg/storage"
+	"github.com/parca-dev/parca/pkg/storage/downsample"
+	"github.com/parca-dev/parca/pkg/storage/metastore"
+)
+
+func Test_Labels_Values_Series_InputValidation(t *testing.T) {
+	ctx := context.Background()
+	end := time.Now()
+	start := end.Add(-5 * time.Minute)
+
+	q := New(nil, nil, nil)
+
+	t.Run("Labels end before start", func(t *testing.T) {
+		resp, err := q.Labels(ctx, &pb.LabelsRequest{Start: timestamppb.New(end), End: timestamppb.New(start)})
+		require.Error(t, err)
+		require.Empty(t, resp)
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("Values empty label name", func(t *testing.T) {
+		resp, err := q.Values(ctx, &pb.ValuesRequest{Start: timestamppb.New(start), End: timestamppb.New(end)})
+		require.Error(t, err)
+		require.Empty(t, resp)
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("Values end before start", func(t *testing.T) {
+		resp, err := q.Values(ctx, &pb.ValuesRequest{LabelName: "__name__", Start: timestamppb.New(end), End: timestamppb.New(start)})
+		require.Error(t, err)
+		require.Empty(t, resp)
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("Series empty matchers", func(t *testing.T) {
+		resp, err := q.Series(ctx, &pb.SeriesRequest{Start: timestamppb.New(start), End: timestamppb.New(end)})
+		require.Error(t, err)
+		require.Empty(t, resp)
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("Series end before start", func(t *testing.T) {
+		resp, err := q.Series(ctx, &pb.SeriesRequest{Match: []string{"allocs"}, Start: timestamppb.New(end), End: timestamppb.New(start)})
+		require.Error(t, err)
+		require.Empty(t, resp)
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+func Test_Labels_Values_Series(t *testing.T) {
+	ctx := context.Background()
+	db := storage.OpenDB(prometheus.NewRegistry())
+	s, err := metastore.NewInMemoryProfileMetaStore("labelsvaluesseries")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		s.Close()
+	})
+	q := New(nil, db, s)
+
+	app, err := db.Appender(ctx, labels.Labels{
+		labels.Label{Name: "__name__", Value: "allocs"},
+		labels.Label{Name: "namespace", Value: "default"},
+	})
+	require.NoError(t, err)
+
+	end := time.Now()
+	start := end.Add(-5 * time.Minute)
+	require.NoError(t, app.Append(&storage.Profile{
+		Meta:    storage.ProfileMeta{Timestamp: end.UnixMilli()},
+		Samples: []*storage.Sample{{Stack: []string{"main"}, Value: 1}},
+	}))
+
+	labelsResp, err := q.Labels(ctx, &pb.LabelsRequest{Start: timestamppb.New(start), End: timestamppb.New(end)})
+	require.NoError(t, err)
+	require.Equal(t, []string{"__name__", "namespace"}, labelsResp.LabelNames)
+
+	valuesResp, err := q.Values(ctx, &pb.ValuesRequest{LabelName: "namespace", Start: timestamppb.New(start), End: timestamppb.New(end)})
+	require.NoError(t, err)
+	require.Equal(t, []string{"default"}, valuesResp.LabelValues)
+
+	// An unknown label name simply has no values, not an error.
+	unknownResp, err := q.Values(ctx, &pb.ValuesRequest{LabelName: "does_not_exist", Start: timestamppb.New(start), End: timestamppb.New(end)})
+	require.NoError(t, err)
+	require.Empty(t, unknownResp.LabelValues)
+
+	seriesResp, err := q.Series(ctx, &pb.SeriesRequest{Match: []string{"allocs"}, Start: timestamppb.New(start), End: timestamppb.New(end)})
+	require.NoError(t, err)
+	require.Len(t, seriesResp.Series, 1)
+}
+
+// Test_Labels_Values_Series_ExcludesDownsampled verifies that a downsampled
+// rollup series (carrying downsample.ResolutionLabel) never surfaces
+// through Labels, Values or Series: that label is an internal storage
+// detail of resolution selection, not something callers should see.
+func Test_Labels_Values_Series_ExcludesDownsampled(t *testing.T) {
+	ctx := context.Background()
+	db := storage.OpenDB(prometheus.NewRegistry())
+	s, err := metastore.NewInMemoryProfileMetaStore("labelsvaluesseriesdownsampled")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		s.Close()
+	})
+	q := New(nil, db, s)
+
+	end := time.Now()
+	start := end.Add(-5 * time.Minute)
+
+	rawApp, err := db.Appender(ctx, labels.Labels{
+		labels.Label{Name: "__name__", Value: "allocs"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, rawApp.Append(&storage.Profile{
+		Meta:    storage.ProfileMeta{Timestamp: end.UnixMilli()},
+		Samples: []*storage.Sample{{Stack: []string{"main"}, Value: 1}},
+	}))
+
+	rollupApp, err := db.Appender(ctx, labels.Labels{
+		labels.Label{Name: "__name__", Value: "allocs"},
+		labels.Label{Name: downsample.ResolutionLabel, Value: string(downsample.Resolution5m)},
+	})
+	require.NoError(t, err)
+	require.NoError(t, rollupApp.Append(&storage.Profile{
+		Meta:    storage.ProfileMeta{Timestamp: end.UnixMilli()},
+		Samples: []*storage.Sample{{Stack: []string{"main"}, Value: 1}},
+	}))
+
+	labelsResp, err := q.Labels(ctx, &pb.LabelsRequest{Start: timestamppb.New(start), End: timestamppb.New(end)})
+	require.NoError(t, err)
+	require.Equal(t, []string{"__name__"}, labelsResp.LabelNames)
+
+	seriesResp, err := q.Series(ctx, &pb.SeriesRequest{Match: []string{"allocs"}, Start: timestamppb.New(start), End: timestamppb.New(end)})
+	require.NoError(t, err)
+	require.Len(t, seriesResp.Series, 1)
+}
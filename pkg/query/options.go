@@ -0,0 +1,62 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/parca-dev/parca/pkg/query/cache"
+	"github.com/parca-dev/parca/pkg/tenant"
+)
+
+// Option configures optional behavior of a Query created with New.
+type Option func(*Query)
+
+// WithCache fronts Query and QueryRange with c, caching serialized
+// responses keyed on the request that produced them. Cache hit/miss counts
+// are registered against reg, which may be nil.
+func WithCache(c cache.Cache, reg prometheus.Registerer) Option {
+	return func(q *Query) {
+		q.cache = c
+		q.cacheHits = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "parca_query_cache_hits_total",
+			Help: "Number of query results served from the result cache.",
+		})
+		q.cacheMisses = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "parca_query_cache_misses_total",
+			Help: "Number of queries not found in the result cache and computed from storage.",
+		})
+	}
+}
+
+// WithTenancy resolves the tenant each request is scoped to using resolver,
+// and enforces the per-tenant limits returned by limits. Without this
+// option the tenant is read from the tenant.OrgIDHeader metadata
+// (tenant.DefaultTenantID when absent) and no limits are enforced.
+func WithTenancy(resolver tenant.Resolver, limits tenant.LimitsProvider) Option {
+	return func(q *Query) {
+		q.tenantResolver = resolver
+		q.limits = limits
+	}
+}
+
+// WithLogQueries makes Query trace every Query and QueryRange call: one
+// structured line at INFO summarizing the request, plus sampling-rate-limited
+// DEBUG lines for the series visited while resolving it.
+func WithLogQueries() Option {
+	return func(q *Query) {
+		q.logQueries = true
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2021 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// parseQuery parses Parca's profile query syntax: a profile name, optionally
+// followed by a PromQL-style label matcher list, e.g. `allocs` or
+// `allocs{namespace="default"}`. The profile name is matched against the
+// `__name__` label, mirroring a Prometheus metric selector.
+func parseQuery(query string) ([]*labels.Matcher, error) {
+	if query == "" {
+		return nil, fmt.Errorf("query must not be empty")
+	}
+
+	name := query
+	rest := ""
+	if i := strings.IndexByte(query, '{'); i >= 0 {
+		name = query[:i]
+		rest = query[i:]
+	}
+
+	matchers := []*labels.Matcher{}
+	if name != "" {
+		matchers = append(matchers, labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, name))
+	}
+
+	if rest == "" {
+		return matchers, nil
+	}
+
+	if !strings.HasPrefix(rest, "{") || !strings.HasSuffix(rest, "}") {
+		return nil, fmt.Errorf("invalid query %q: malformed label matchers", query)
+	}
+	rest = strings.TrimSuffix(strings.TrimPrefix(rest, "{"), "}")
+	if rest == "" {
+		return matchers, nil
+	}
+
+	for _, pair := range strings.Split(rest, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		eq := strings.IndexByte(pair, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("invalid query %q: expected label=\"value\"", query)
+		}
+		lname := strings.TrimSpace(pair[:eq])
+		lvalue := strings.Trim(strings.TrimSpace(pair[eq+1:]), `"`)
+		matchers = append(matchers, labels.MustNewMatcher(labels.MatchEqual, lname, lvalue))
+	}
+
+	return matchers, nil
+}
@@ -0,0 +1,437 @@
+// Copyright 2021 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package query implements the query.proto gRPC service: turning a stored
+// query, time range and mode into a report (today, only flamegraphs).
+package query
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
+	"github.com/parca-dev/parca/pkg/query/cache"
+	"github.com/parca-dev/parca/pkg/storage"
+	"github.com/parca-dev/parca/pkg/storage/downsample"
+	"github.com/parca-dev/parca/pkg/storage/metastore"
+	"github.com/parca-dev/parca/pkg/tenant"
+)
+
+// targetResolutionPixels is the assumed width, in pixels, of the series
+// timeline QueryRange renders into. It's used to turn a time range into a
+// step duration when the caller doesn't set ResolutionHint, the same way a
+// Grafana panel picks a step from its pixel width.
+const targetResolutionPixels = 1440
+
+// Query implements the query.v1alpha1 QueryService against a storage.DB and
+// its associated metastore.
+type Query struct {
+	logger    *slog.Logger
+	db        *storage.DB
+	metaStore metastore.ProfileMetaStore
+
+	cache       cache.Cache
+	cacheHits   prometheus.Counter
+	cacheMisses prometheus.Counter
+
+	tenantResolver tenant.Resolver
+	limits         tenant.LimitsProvider
+	concurrency    *tenant.Limiter
+
+	logQueries bool
+}
+
+// New returns a ready to use Query. logger may be nil, in which case a
+// no-op logger is used. Pass WithCache to front Query and QueryRange with a
+// result cache; without it, every request is served straight from db. Pass
+// WithTenancy to supply a tenant.Resolver and enforce per-tenant limits;
+// without it, the tenant is read from the tenant.OrgIDHeader metadata
+// (tenant.DefaultTenantID when absent) and no limits are enforced. Pass
+// WithLogQueries to trace
+// every request at INFO, with sampled per-series detail at DEBUG.
+func New(logger *slog.Logger, db *storage.DB, metaStore metastore.ProfileMetaStore, opts ...Option) *Query {
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	q := &Query{
+		logger:         logger,
+		db:             db,
+		metaStore:      metaStore,
+		tenantResolver: tenant.NewResolver(),
+		limits:         tenant.StaticLimits{},
+		concurrency:    tenant.NewLimiter(),
+	}
+	for _, opt := range opts {
+		opt(q)
+	}
+	return q
+}
+
+// acquireTenant resolves the tenant ctx is scoped to and reserves it a
+// concurrency slot, returning a function to release it. Callers must defer
+// the release on every non-error return.
+func (q *Query) acquireTenant(ctx context.Context) (tenantID string, release func(), err error) {
+	tenantID, err = q.tenantResolver.TenantID(ctx)
+	if err != nil {
+		return "", nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	release, ok := q.concurrency.Acquire(tenantID, q.limits.LimitsFor(tenantID).MaxConcurrentQueries)
+	if !ok {
+		return "", nil, status.Error(codes.ResourceExhausted, fmt.Sprintf("tenant %q has too many concurrent queries in flight", tenantID))
+	}
+	return tenantID, release, nil
+}
+
+// QueryRange returns the timeline of sample values for Query's matching
+// series within [Start, End], capped to Limit series.
+func (q *Query) QueryRange(ctx context.Context, req *pb.QueryRangeRequest) (*pb.QueryRangeResponse, error) {
+	started := time.Now()
+
+	if req.GetQuery() == "" {
+		return nil, status.Error(codes.InvalidArgument, "query must not be empty")
+	}
+	if req.GetStart() == nil || req.GetEnd() == nil {
+		return nil, status.Error(codes.InvalidArgument, "start and end must be specified")
+	}
+	start := req.GetStart().AsTime()
+	end := req.GetEnd().AsTime()
+	if end.Before(start) {
+		return nil, status.Error(codes.InvalidArgument, "end must be after start")
+	}
+
+	tenantID, release, err := q.acquireTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	matchers, err := parseQuery(req.GetQuery())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	resolvedMatchers, res := q.resolveMatchers(ctx, matchers, req.GetResolutionHint(), start, end)
+	q.logger.Debug("resolved query range resolution", "resolution", string(res))
+
+	if limit := q.limits.LimitsFor(tenantID).MaxSeries; limit > 0 {
+		if matched := q.seriesWithDataCount(ctx, resolvedMatchers, start, end); matched > limit {
+			return nil, status.Error(codes.ResourceExhausted, fmt.Sprintf("query matched %d series, exceeding tenant %q's limit of %d", matched, tenantID, limit))
+		}
+	}
+
+	var (
+		resp     *pb.QueryRangeResponse
+		cacheHit bool
+	)
+	if q.cache != nil {
+		resp, cacheHit, err = q.queryRangeCached(ctx, tenantID, req.GetQuery(), resolvedMatchers, res, start, end)
+	} else {
+		resp, err = q.queryRangeDirect(ctx, resolvedMatchers, start, end)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	q.logQueryRangeResult(req.GetQuery(), start, end, resp, cacheHit, started)
+
+	if req.GetLimit() > 0 && int(req.GetLimit()) < len(resp.Series) {
+		resp.Series = resp.Series[:req.GetLimit()]
+	}
+	return resp, nil
+}
+
+// seriesWithDataCount returns how many of matchers' matching series have
+// at least one profile in [start, end], the same filter
+// queryRangeDirect/queryRangeCached apply before returning series. MaxSeries
+// is enforced against this count, not against every label-set match, so a
+// selector matching many series system-wide isn't penalized for series that
+// have no data in the requested window.
+func (q *Query) seriesWithDataCount(ctx context.Context, matchers []*labels.Matcher, start, end time.Time) int {
+	mint, maxt := start.UnixMilli(), end.UnixMilli()
+	n := 0
+	for _, s := range q.db.Select(ctx, matchers...) {
+		if len(s.Profiles(mint, maxt)) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// queryRangeDirect computes the QueryRangeResponse for matchers over
+// [start, end] straight from storage, with no caching.
+func (q *Query) queryRangeDirect(ctx context.Context, matchers []*labels.Matcher, start, end time.Time) (*pb.QueryRangeResponse, error) {
+	mint, maxt := start.UnixMilli(), end.UnixMilli()
+	series := q.db.Select(ctx, matchers...)
+
+	resp := &pb.QueryRangeResponse{Series: make([]*pb.Series, 0, len(series))}
+	for _, s := range series {
+		profiles := s.Profiles(mint, maxt)
+		if len(profiles) == 0 {
+			continue
+		}
+		q.logSeriesSampled(s.Lset, len(profiles))
+
+		out := &pb.Series{Labelset: labelSetToPB(s.Lset)}
+		for _, p := range profiles {
+			var total int64
+			for _, sample := range p.Samples {
+				total += sample.Value
+			}
+			out.Samples = append(out.Samples, &pb.SampleValue{
+				Timestamp: timestampFromMillis(p.Meta.Timestamp),
+				Value:     total,
+			})
+		}
+		resp.Series = append(resp.Series, out)
+	}
+
+	return resp, nil
+}
+
+// Query resolves a single report (flamegraph, today) according to req's
+// mode: a single stored profile, a merge over a time range, or a diff
+// between two such selections. Successful responses are cached, when a
+// cache is configured, keyed on the request that produced them.
+func (q *Query) Query(ctx context.Context, req *pb.QueryRequest) (*pb.QueryResponse, error) {
+	started := time.Now()
+
+	tenantID, release, err := q.acquireTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if q.cache == nil {
+		resp, stats, err := q.computeQuery(ctx, req, tenantID)
+		if err != nil {
+			return nil, err
+		}
+		q.logQueryResult(req, stats, false, started)
+		return resp, nil
+	}
+
+	key := tenantID + ":" + queryCacheKey(req)
+	if b, ok := q.cache.Get(key); ok {
+		if resp, err := decodeQueryResponse(b); err == nil {
+			q.cacheHits.Inc()
+			q.logQueryResult(req, queryStats{}, true, started)
+			return resp, nil
+		}
+	}
+	q.cacheMisses.Inc()
+
+	resp, stats, err := q.computeQuery(ctx, req, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	q.logQueryResult(req, stats, false, started)
+
+	if b, err := encodeQueryResponse(resp); err == nil {
+		q.cache.Store(map[string][]byte{key: b}, defaultQueryCacheTTL)
+	} else {
+		q.logger.Debug("failed to encode query response for caching", "err", err)
+	}
+	return resp, nil
+}
+
+// queryStats summarizes the work a computeQuery call did, for WithLogQueries
+// tracing.
+type queryStats struct {
+	SeriesScanned  int
+	SamplesScanned int
+}
+
+// computeQuery resolves req straight from storage, with no caching.
+func (q *Query) computeQuery(ctx context.Context, req *pb.QueryRequest, tenantID string) (*pb.QueryResponse, queryStats, error) {
+	switch req.GetReportType() {
+	case pb.QueryRequest_REPORT_TYPE_FLAMEGRAPH_UNSPECIFIED:
+	default:
+		return nil, queryStats{}, status.Error(codes.InvalidArgument, "unsupported report type")
+	}
+
+	switch req.GetMode() {
+	case pb.QueryRequest_MODE_SINGLE_UNSPECIFIED:
+		single, ok := req.GetOptions().(*pb.QueryRequest_Single)
+		if !ok || single.Single == nil {
+			return nil, queryStats{}, status.Error(codes.InvalidArgument, "single mode requires a single profile selection")
+		}
+		samples, seriesScanned, err := q.singleSamples(ctx, single.Single)
+		if err != nil {
+			return nil, queryStats{}, err
+		}
+		stats := queryStats{SeriesScanned: seriesScanned, SamplesScanned: len(samples)}
+		if err := q.enforceSamplesLimit(tenantID, stats.SamplesScanned); err != nil {
+			return nil, stats, err
+		}
+		return &pb.QueryResponse{Report: &pb.QueryResponse_Flamegraph{Flamegraph: flamegraphFromSamples(samples)}}, stats, nil
+
+	case pb.QueryRequest_MODE_MERGE:
+		merge, ok := req.GetOptions().(*pb.QueryRequest_Merge)
+		if !ok || merge.Merge == nil {
+			return nil, queryStats{}, status.Error(codes.InvalidArgument, "merge mode requires a merge profile selection")
+		}
+		samples, seriesScanned, err := q.mergeSamples(ctx, merge.Merge)
+		if err != nil {
+			return nil, queryStats{}, err
+		}
+		stats := queryStats{SeriesScanned: seriesScanned, SamplesScanned: len(samples)}
+		if err := q.enforceSamplesLimit(tenantID, stats.SamplesScanned); err != nil {
+			return nil, stats, err
+		}
+		return &pb.QueryResponse{Report: &pb.QueryResponse_Flamegraph{Flamegraph: flamegraphFromSamples(samples)}}, stats, nil
+
+	case pb.QueryRequest_MODE_DIFF:
+		diff, ok := req.GetOptions().(*pb.QueryRequest_Diff)
+		if !ok || diff.Diff == nil || diff.Diff.A == nil || diff.Diff.B == nil {
+			return nil, queryStats{}, status.Error(codes.InvalidArgument, "diff mode requires both a and b selections")
+		}
+		a, aSeries, err := q.selectionSamples(ctx, diff.Diff.A)
+		if err != nil {
+			return nil, queryStats{}, err
+		}
+		b, bSeries, err := q.selectionSamples(ctx, diff.Diff.B)
+		if err != nil {
+			return nil, queryStats{}, err
+		}
+		stats := queryStats{SeriesScanned: aSeries + bSeries, SamplesScanned: len(a) + len(b)}
+		if err := q.enforceSamplesLimit(tenantID, stats.SamplesScanned); err != nil {
+			return nil, stats, err
+		}
+		return &pb.QueryResponse{Report: &pb.QueryResponse_Flamegraph{Flamegraph: flamegraphDiff(a, b)}}, stats, nil
+
+	default:
+		return nil, queryStats{}, status.Error(codes.InvalidArgument, "unknown query mode")
+	}
+}
+
+// enforceSamplesLimit returns a codes.ResourceExhausted error if n exceeds
+// tenantID's configured MaxSamplesScanned.
+func (q *Query) enforceSamplesLimit(tenantID string, n int) error {
+	if limit := q.limits.LimitsFor(tenantID).MaxSamplesScanned; limit > 0 && n > limit {
+		return status.Error(codes.ResourceExhausted, fmt.Sprintf("query scanned %d samples, exceeding tenant %q's limit of %d", n, tenantID, limit))
+	}
+	return nil
+}
+
+// resolveMatchers resolves the resolution QueryRange should read from (the
+// resolution named by hint if set, otherwise the coarsest downsampled
+// resolution whose step still fits within [start, end] at
+// targetResolutionPixels) and returns matchers extended with the matching
+// `__resolution__` matcher. An auto-selected resolution with no data in
+// [start, end] yet (e.g. the downsampler hasn't run for that window) falls
+// back to raw series, so a fresh range never returns empty just because
+// it's wide enough to prefer a rollup. A resolution named by hint is used
+// as is, with no fallback.
+func (q *Query) resolveMatchers(ctx context.Context, matchers []*labels.Matcher, hint string, start, end time.Time) ([]*labels.Matcher, downsample.Resolution) {
+	res := downsample.Resolution(hint)
+	if hint == "" {
+		step := end.Sub(start) / targetResolutionPixels
+		res = downsample.PickResolution(step)
+	}
+
+	matchersAt := func(r downsample.Resolution) []*labels.Matcher {
+		resolutionMatcher := labels.MustNewMatcher(labels.MatchEqual, downsample.ResolutionLabel, string(r))
+		return append(append([]*labels.Matcher{}, matchers...), resolutionMatcher)
+	}
+
+	if res == downsample.ResolutionRaw {
+		return matchersAt(res), res
+	}
+
+	resolvedMatchers := matchersAt(res)
+	if q.seriesWithDataCount(ctx, resolvedMatchers, start, end) > 0 || hint != "" {
+		return resolvedMatchers, res
+	}
+
+	// Auto-selected a resolution that has no data yet; fall back to raw
+	// rather than returning an empty range.
+	return matchersAt(downsample.ResolutionRaw), downsample.ResolutionRaw
+}
+
+// selectionSamples returns sel's samples along with the number of series
+// scanned to produce them, for WithLogQueries tracing.
+func (q *Query) selectionSamples(ctx context.Context, sel *pb.ProfileDiffSelection) ([]*storage.Sample, int, error) {
+	switch sel.GetMode() {
+	case pb.ProfileDiffSelection_MODE_SINGLE_UNSPECIFIED:
+		if sel.GetSingle() == nil {
+			return nil, 0, status.Error(codes.InvalidArgument, "single selection requires a single profile")
+		}
+		return q.singleSamples(ctx, sel.GetSingle())
+	case pb.ProfileDiffSelection_MODE_MERGE:
+		if sel.GetMerge() == nil {
+			return nil, 0, status.Error(codes.InvalidArgument, "merge selection requires a merge profile")
+		}
+		return q.mergeSamples(ctx, sel.GetMerge())
+	default:
+		return nil, 0, status.Error(codes.InvalidArgument, "unknown diff selection mode")
+	}
+}
+
+func (q *Query) singleSamples(ctx context.Context, sel *pb.SingleProfile) ([]*storage.Sample, int, error) {
+	matchers, err := parseQuery(sel.GetQuery())
+	if err != nil {
+		return nil, 0, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	ts := sel.GetTime().AsTime().UnixMilli()
+	series := q.db.Select(ctx, append(matchers, rawOnlyMatcher)...)
+	for _, s := range series {
+		for _, p := range s.Profiles(ts, ts) {
+			q.logSeriesSampled(s.Lset, 1)
+			return p.Samples, len(series), nil
+		}
+	}
+	return nil, len(series), nil
+}
+
+func (q *Query) mergeSamples(ctx context.Context, sel *pb.MergeProfile) ([]*storage.Sample, int, error) {
+	matchers, err := parseQuery(sel.GetQuery())
+	if err != nil {
+		return nil, 0, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	mint, maxt := sel.GetStart().AsTime().UnixMilli(), sel.GetEnd().AsTime().UnixMilli()
+	series := q.db.Select(ctx, append(matchers, rawOnlyMatcher)...)
+	var samples []*storage.Sample
+	for _, s := range series {
+		profiles := s.Profiles(mint, maxt)
+		q.logSeriesSampled(s.Lset, len(profiles))
+		for _, p := range profiles {
+			samples = append(samples, p.Samples...)
+		}
+	}
+	return samples, len(series), nil
+}
+
+func timestampFromMillis(ms int64) *timestamppb.Timestamp {
+	return timestamppb.New(time.UnixMilli(ms))
+}
+
+func labelSetToPB(lset labels.Labels) *profilestorepb.LabelSet {
+	out := &profilestorepb.LabelSet{Labels: make([]*profilestorepb.Label, 0, len(lset))}
+	for _, l := range lset {
+		out.Labels = append(out.Labels, &profilestorepb.Label{Name: l.Name, Value: l.Value})
+	}
+	return out
+}
@@ -14,33 +14,38 @@
 package query
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"log/slog"
 	"math"
 	"math/rand"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/go-kit/log"
 	"github.com/google/pprof/profile"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
 	profilestore "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
 	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
 	"github.com/parca-dev/parca/pkg/storage"
+	"github.com/parca-dev/parca/pkg/storage/downsample"
 	"github.com/parca-dev/parca/pkg/storage/metastore"
+	"github.com/parca-dev/parca/pkg/tenant"
 )
 
 func Test_QueryRange_EmptyStore(t *testing.T) {
 	ctx := context.Background()
 	db := storage.OpenDB(prometheus.NewRegistry())
-	q := New(log.NewNopLogger(), db, nil)
+	q := New(nil, db, nil)
 
 	// Query last 5 minutes
 	end := time.Now()
@@ -64,7 +69,7 @@ func Test_QueryRange_Valid(t *testing.T) {
 		s.Close()
 	})
 	require.NoError(t, err)
-	q := New(log.NewNopLogger(), db, s)
+	q := New(nil, db, s)
 
 	app, err := db.Appender(ctx, labels.Labels{
 		labels.Label{
@@ -82,7 +87,7 @@ func Test_QueryRange_Valid(t *testing.T) {
 	// Overwrite the profile's timestamp to be within the last 5min.
 	p.TimeNanos = time.Now().UnixNano()
 
-	err = app.Append(storage.ProfileFromPprof(log.NewNopLogger(), s, p, 0))
+	err = app.Append(storage.ProfileFromPprof(nil, s, p, 0))
 	require.NoError(t, err)
 
 	// Query last 5 minutes
@@ -118,14 +123,20 @@ func Test_QueryRange_Limited(t *testing.T) {
 		s.Close()
 	})
 	require.NoError(t, err)
-	q := New(log.NewNopLogger(), db, s)
+
+	numSeries := 10
+	// maxSeries is always < numSeries (and never 0, which would mean
+	// unlimited), so the query below always exceeds it.
+	maxSeries := rand.Intn(numSeries-1) + 1
+	q := New(nil, db, s, WithTenancy(tenant.NewResolver(), tenant.StaticLimits{
+		Default: tenant.Limits{MaxSeries: maxSeries},
+	}))
 
 	f, err := os.Open("testdata/alloc_objects.pb.gz")
 	require.NoError(t, err)
 	p, err := profile.Parse(f)
 	require.NoError(t, err)
 
-	numSeries := 10
 	for i := 0; i < numSeries; i++ {
 		app, err := db.Appender(ctx, labels.Labels{
 			labels.Label{
@@ -142,7 +153,7 @@ func Test_QueryRange_Limited(t *testing.T) {
 		// Overwrite the profile's timestamp to be within the last 5min.
 		p.TimeNanos = time.Now().UnixNano()
 
-		err = app.Append(storage.ProfileFromPprof(log.NewNopLogger(), s, p, 0))
+		err = app.Append(storage.ProfileFromPprof(nil, s, p, 0))
 		require.NoError(t, err)
 	}
 
@@ -150,19 +161,172 @@ func Test_QueryRange_Limited(t *testing.T) {
 	end := time.Now()
 	start := end.Add(-5 * time.Minute)
 
-	limit := rand.Intn(numSeries)
+	// numSeries exceeds the tenant's MaxSeries, so the query is rejected
+	// server-side rather than silently truncated.
 	resp, err := q.QueryRange(ctx, &pb.QueryRangeRequest{
 		Query: "allocs",
 		Start: timestamppb.New(start),
 		End:   timestamppb.New(end),
-		Limit: uint32(limit),
 	})
+	require.Error(t, err)
+	require.Empty(t, resp)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+// Test_QueryRange_MaxSeries_CountsOnlyDataInRange verifies that
+// MaxSeries is enforced against series that actually have data in the
+// queried range, not every series whose label set matches: a tenant's
+// selector matching many series system-wide shouldn't be penalized for
+// series outside the query window.
+func Test_QueryRange_MaxSeries_CountsOnlyDataInRange(t *testing.T) {
+	ctx := context.Background()
+	db := storage.OpenDB(prometheus.NewRegistry())
+	s, err := metastore.NewInMemoryProfileMetaStore("queryrangemaxseriesinrange")
 	require.NoError(t, err)
-	require.NotEmpty(t, resp.Series)
-	require.Equal(t, limit, len(resp.Series))
-	for i := 0; i < limit; i++ {
-		require.Equal(t, 1, len(resp.Series[i].Samples))
+	t.Cleanup(func() {
+		s.Close()
+	})
+
+	// MaxSeries is below the total number of matching series, but above
+	// the number that actually have data in the queried window.
+	q := New(nil, db, s, WithTenancy(tenant.NewResolver(), tenant.StaticLimits{
+		Default: tenant.Limits{MaxSeries: 2},
+	}))
+
+	f, err := os.Open("testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+	p, err := profile.Parse(f)
+	require.NoError(t, err)
+
+	end := time.Now()
+	start := end.Add(-5 * time.Minute)
+
+	// One series with data inside the queried window.
+	appIn, err := db.Appender(ctx, labels.Labels{
+		labels.Label{Name: "__name__", Value: "allocs"},
+		labels.Label{Name: "meta", Value: "in_range"},
+	})
+	require.NoError(t, err)
+	p.TimeNanos = end.UnixNano()
+	require.NoError(t, appIn.Append(storage.ProfileFromPprof(nil, s, p, 0)))
+
+	// Several series matching the same selector, but whose only data
+	// falls well outside the queried window.
+	for i := 0; i < 5; i++ {
+		app, err := db.Appender(ctx, labels.Labels{
+			labels.Label{Name: "__name__", Value: "allocs"},
+			labels.Label{Name: "meta", Value: fmt.Sprintf("out_of_range_%v", i)},
+		})
+		require.NoError(t, err)
+		p.TimeNanos = start.Add(-time.Hour).UnixNano()
+		require.NoError(t, app.Append(storage.ProfileFromPprof(nil, s, p, 0)))
+	}
+
+	resp, err := q.QueryRange(ctx, &pb.QueryRangeRequest{
+		Query: "allocs",
+		Start: timestamppb.New(start),
+		End:   timestamppb.New(end),
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Series, 1)
+}
+
+// Test_QueryRange_RequestLimit verifies that QueryRangeRequest.Limit
+// truncates the response series, independent of any tenant MaxSeries
+// limit (unset here).
+func Test_QueryRange_RequestLimit(t *testing.T) {
+	ctx := context.Background()
+	db := storage.OpenDB(prometheus.NewRegistry())
+	s, err := metastore.NewInMemoryProfileMetaStore("queryrangerequestlimit")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		s.Close()
+	})
+	q := New(nil, db, s)
+
+	f, err := os.Open("testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+	p, err := profile.Parse(f)
+	require.NoError(t, err)
+
+	numSeries := 5
+	limit := 2
+	for i := 0; i < numSeries; i++ {
+		app, err := db.Appender(ctx, labels.Labels{
+			labels.Label{
+				Name:  "__name__",
+				Value: "allocs",
+			},
+			labels.Label{
+				Name:  "meta",
+				Value: fmt.Sprintf("series_%v", i),
+			},
+		})
+		require.NoError(t, err)
+
+		p.TimeNanos = time.Now().UnixNano()
+
+		err = app.Append(storage.ProfileFromPprof(nil, s, p, 0))
+		require.NoError(t, err)
 	}
+
+	end := time.Now()
+	start := end.Add(-5 * time.Minute)
+
+	resp, err := q.QueryRange(ctx, &pb.QueryRangeRequest{
+		Query: "allocs",
+		Start: timestamppb.New(start),
+		End:   timestamppb.New(end),
+		Limit: uint32(limit),
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Series, limit)
+}
+
+// Test_Tenant_Isolation verifies that two tenants appending an identical
+// label set end up with distinct series, and neither can select the
+// other's data.
+func Test_Tenant_Isolation(t *testing.T) {
+	db := storage.OpenDB(prometheus.NewRegistry())
+	s, err := metastore.NewInMemoryProfileMetaStore("tenantisolation")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		s.Close()
+	})
+	q := New(nil, db, s, WithTenancy(tenant.NewResolver(), tenant.StaticLimits{}))
+
+	ctxA := metadata.NewIncomingContext(context.Background(), metadata.Pairs(tenant.OrgIDHeader, "tenant-a"))
+	ctxB := metadata.NewIncomingContext(context.Background(), metadata.Pairs(tenant.OrgIDHeader, "tenant-b"))
+
+	lset := labels.Labels{labels.Label{Name: "__name__", Value: "allocs"}}
+
+	appA, err := db.Appender(ctxA, lset)
+	require.NoError(t, err)
+	f, err := os.Open("testdata/alloc_objects.pb.gz")
+	require.NoError(t, err)
+	p, err := profile.Parse(f)
+	require.NoError(t, err)
+	p.TimeNanos = time.Now().UnixNano()
+	require.NoError(t, appA.Append(storage.ProfileFromPprof(nil, s, p, 0)))
+
+	end := time.Now()
+	start := end.Add(-5 * time.Minute)
+
+	respA, err := q.QueryRange(ctxA, &pb.QueryRangeRequest{
+		Query: "allocs",
+		Start: timestamppb.New(start),
+		End:   timestamppb.New(end),
+	})
+	require.NoError(t, err)
+	require.Len(t, respA.Series, 1)
+
+	respB, err := q.QueryRange(ctxB, &pb.QueryRangeRequest{
+		Query: "allocs",
+		Start: timestamppb.New(start),
+		End:   timestamppb.New(end),
+	})
+	require.NoError(t, err)
+	require.Empty(t, respB.Series)
 }
 
 func Test_QueryRange_InputValidation(t *testing.T) {
@@ -203,7 +367,7 @@ func Test_QueryRange_InputValidation(t *testing.T) {
 		},
 	}
 
-	q := New(log.NewNopLogger(), nil, nil)
+	q := New(nil, nil, nil)
 
 	t.Parallel()
 	for name, test := range tests {
@@ -255,7 +419,7 @@ func Test_Query_InputValidation(t *testing.T) {
 		},
 	}
 
-	q := New(log.NewNopLogger(), nil, nil)
+	q := New(nil, nil, nil)
 
 	t.Parallel()
 	for name, test := range tests {
@@ -276,7 +440,7 @@ func Test_Query_Simple(t *testing.T) {
 	t.Cleanup(func() {
 		s.Close()
 	})
-	q := New(log.NewNopLogger(), db, s)
+	q := New(nil, db, s)
 
 	app, err := db.Appender(ctx, labels.Labels{
 		labels.Label{
@@ -295,7 +459,7 @@ func Test_Query_Simple(t *testing.T) {
 	t1 := (time.Now().UnixNano() / 1000000) * 1000000
 	p1.TimeNanos = t1
 
-	err = app.Append(storage.ProfileFromPprof(log.NewNopLogger(), s, p1, 0))
+	err = app.Append(storage.ProfileFromPprof(nil, s, p1, 0))
 	require.NoError(t, err)
 
 	_, err = q.Query(ctx, &pb.QueryRequest{
@@ -324,7 +488,7 @@ func Test_Query_Diff(t *testing.T) {
 	t.Cleanup(func() {
 		s.Close()
 	})
-	q := New(log.NewNopLogger(), db, s)
+	q := New(nil, db, s)
 
 	app, err := db.Appender(ctx, labels.Labels{
 		labels.Label{
@@ -349,7 +513,7 @@ func Test_Query_Diff(t *testing.T) {
 	t1 := (time.Now().UnixNano() / 1000000) * 1000000
 	p1.TimeNanos = t1
 
-	err = app.Append(storage.ProfileFromPprof(log.NewNopLogger(), s, p1, 0))
+	err = app.Append(storage.ProfileFromPprof(nil, s, p1, 0))
 	require.NoError(t, err)
 
 	time.Sleep(time.Millisecond * 10)
@@ -357,7 +521,7 @@ func Test_Query_Diff(t *testing.T) {
 	t2 := (time.Now().UnixNano() / 1000000) * 1000000
 	p2.TimeNanos = t2
 
-	err = app.Append(storage.ProfileFromPprof(log.NewNopLogger(), s, p2, 0))
+	err = app.Append(storage.ProfileFromPprof(nil, s, p2, 0))
 	require.NoError(t, err)
 
 	_, err = q.Query(ctx, &pb.QueryRequest{
@@ -405,7 +569,7 @@ func Benchmark_Query_Merge(b *testing.B) {
 	require.NoError(b, err)
 	require.NoError(b, f.Close())
 
-	p := storage.ProfileFromPprof(log.NewNopLogger(), s, p1, 0)
+	p := storage.ProfileFromPprof(nil, s, p1, 0)
 
 	for k := 0.; k <= 10; k++ {
 		n := int(math.Pow(2, k))
@@ -414,7 +578,7 @@ func Benchmark_Query_Merge(b *testing.B) {
 				b.StopTimer()
 				ctx := context.Background()
 				db := storage.OpenDB(prometheus.NewRegistry())
-				q := New(log.NewNopLogger(), db, s)
+				q := New(nil, db, s)
 
 				app, err := db.Appender(ctx, labels.Labels{
 					labels.Label{
@@ -459,12 +623,12 @@ func Test_Query_Merge(t *testing.T) {
 	require.NoError(t, err)
 	require.NoError(t, f.Close())
 
-	p := storage.ProfileFromPprof(log.NewNopLogger(), s, p1, 0)
+	p := storage.ProfileFromPprof(nil, s, p1, 0)
 
 	for k := 0.; k <= 10; k++ {
 		ctx := context.Background()
 		db := storage.OpenDB(prometheus.NewRegistry())
-		q := New(log.NewNopLogger(), db, s)
+		q := New(nil, db, s)
 
 		app, err := db.Appender(ctx, labels.Labels{
 			labels.Label{
@@ -497,3 +661,81 @@ func Test_Query_Merge(t *testing.T) {
 		})
 	}
 }
+
+// Test_Query_Single_Merge_ExcludesDownsampled verifies that singleSamples
+// and mergeSamples, the series selection behind the Query RPC's single and
+// merge modes, never pick up a downsampled rollup series: unlike
+// QueryRange, they have no resolution-aware selection of their own, so
+// rawOnlyMatcher is the only thing keeping a rollup's duplicated samples
+// out of a Query response.
+func Test_Query_Single_Merge_ExcludesDownsampled(t *testing.T) {
+	ctx := context.Background()
+	db := storage.OpenDB(prometheus.NewRegistry())
+	s, err := metastore.NewInMemoryProfileMetaStore("querysingledownsampled")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		s.Close()
+	})
+	q := New(nil, db, s)
+
+	rawApp, err := db.Appender(ctx, labels.Labels{
+		labels.Label{Name: "__name__", Value: "allocs"},
+	})
+	require.NoError(t, err)
+
+	rollupApp, err := db.Appender(ctx, labels.Labels{
+		labels.Label{Name: "__name__", Value: "allocs"},
+		labels.Label{Name: downsample.ResolutionLabel, Value: string(downsample.Resolution5m)},
+	})
+	require.NoError(t, err)
+
+	ts := (time.Now().UnixNano() / 1000000) * 1000000
+	for _, app := range []storage.Appender{rawApp, rollupApp} {
+		require.NoError(t, app.Append(&storage.Profile{
+			Meta:    storage.ProfileMeta{Timestamp: ts},
+			Samples: []*storage.Sample{{Stack: []string{"main"}, Value: 1}},
+		}))
+	}
+
+	_, numSeries, err := q.singleSamples(ctx, &pb.SingleProfile{
+		Query: "allocs",
+		Time:  timestamppb.New(time.UnixMilli(ts)),
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, numSeries)
+
+	_, numSeries, err = q.mergeSamples(ctx, &pb.MergeProfile{
+		Query: "allocs",
+		Start: timestamppb.New(time.UnixMilli(ts)),
+		End:   timestamppb.New(time.UnixMilli(ts)),
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, numSeries)
+}
+
+// Test_QueryRange_LogQueries verifies that WithLogQueries emits one
+// structured summary line per request.
+func Test_QueryRange_LogQueries(t *testing.T) {
+	ctx := context.Background()
+	db := storage.OpenDB(prometheus.NewRegistry())
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	q := New(logger, db, nil, WithLogQueries())
+
+	end := time.Now()
+	start := end.Add(-5 * time.Minute)
+	resp, err := q.QueryRange(ctx, &pb.QueryRangeRequest{
+		Query: "allocs",
+		Start: timestamppb.New(start),
+		End:   timestamppb.New(end),
+	})
+	require.NoError(t, err)
+	require.Empty(t, resp.Series)
+
+	out := buf.String()
+	require.Contains(t, out, "msg=\"query range\"")
+	require.Contains(t, out, "query=allocs")
+	require.Contains(t, out, "cache_hit=false")
+	require.Equal(t, 1, strings.Count(out, "msg=\"query range\""))
+}
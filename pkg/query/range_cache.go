@@ -0,0 +1,147 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package query
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+
+	profilestorepb "github.com/parca-dev/parca/gen/proto/go/parca/profilestore/v1alpha1"
+	pb "github.com/parca-dev/parca/gen/proto/go/parca/query/v1alpha1"
+	"github.com/parca-dev/parca/pkg/storage/downsample"
+)
+
+// queryRangeChunk is the width QueryRange splits a request's time range
+// into for caching purposes: every chunk but the one still accumulating
+// data (the one overlapping "now") is immutable and cached independently,
+// so panning the UI by a few minutes only has to recompute that last
+// chunk instead of the whole range.
+const queryRangeChunk = time.Hour
+
+// defaultRangeCacheTTL bounds how long a cached, immutable chunk is kept
+// before QueryRange recomputes it from storage regardless.
+const defaultRangeCacheTTL = 24 * time.Hour
+
+// defaultQueryCacheTTL bounds how long a cached Query (flamegraph) response
+// is kept before it's recomputed from storage regardless.
+const defaultQueryCacheTTL = time.Hour
+
+// queryRangeCached serves a QueryRange request chunk by chunk, reusing
+// cached, already-complete chunks and only computing (and caching) chunks
+// that missed.
+func (q *Query) queryRangeCached(ctx context.Context, tenantID, query string, matchers []*labels.Matcher, res downsample.Resolution, start, end time.Time) (*pb.QueryRangeResponse, bool, error) {
+	now := time.Now()
+
+	seriesByKey := map[string]*pb.Series{}
+	var order []string
+	allCached := true
+
+	for chunkStart := start.Truncate(queryRangeChunk); chunkStart.Before(end); chunkStart = chunkStart.Add(queryRangeChunk) {
+		chunkEnd := chunkStart.Add(queryRangeChunk)
+
+		windowStart, windowEnd := maxTime(chunkStart, start), minTime(chunkEnd, end)
+		if !windowStart.Before(windowEnd) {
+			continue
+		}
+		// Profiles are selected inclusive of both ends, so a window that
+		// stops at chunkEnd leaves that millisecond to the next chunk;
+		// otherwise a profile on the boundary would be counted twice.
+		if windowEnd.Equal(chunkEnd) && chunkEnd.Before(end) {
+			windowEnd = chunkEnd.Add(-time.Millisecond)
+		}
+
+		// The chunk containing "now" is still accumulating samples and must
+		// never be served from (or written to) the cache.
+		cacheable := !chunkEnd.After(now)
+
+		var (
+			chunkResp *pb.QueryRangeResponse
+			cacheKey  = rangeCacheKey(tenantID, query, res, chunkStart, chunkEnd)
+		)
+
+		if cacheable {
+			if b, ok := q.cache.Get(cacheKey); ok {
+				if resp, err := decodeQueryRangeResponse(b); err == nil {
+					q.cacheHits.Inc()
+					chunkResp = resp
+				}
+			}
+		}
+
+		if chunkResp == nil {
+			q.cacheMisses.Inc()
+			allCached = false
+
+			resp, err := q.queryRangeDirect(ctx, matchers, windowStart, windowEnd)
+			if err != nil {
+				return nil, false, err
+			}
+			chunkResp = resp
+
+			if cacheable {
+				if b, err := encodeQueryRangeResponse(resp); err == nil {
+					q.cache.Store(map[string][]byte{cacheKey: b}, defaultRangeCacheTTL)
+				}
+			}
+		}
+
+		for _, s := range chunkResp.Series {
+			key := labelSetKey(s.Labelset)
+			existing, ok := seriesByKey[key]
+			if !ok {
+				seriesByKey[key] = s
+				order = append(order, key)
+				continue
+			}
+			existing.Samples = append(existing.Samples, s.Samples...)
+		}
+	}
+
+	out := &pb.QueryRangeResponse{Series: make([]*pb.Series, 0, len(order))}
+	for _, key := range order {
+		out.Series = append(out.Series, seriesByKey[key])
+	}
+	return out, allCached, nil
+}
+
+// rangeCacheKey builds a cache key scoped to tenantID, so two tenants
+// querying the same range never share a cached result.
+func rangeCacheKey(tenantID, query string, res downsample.Resolution, chunkStart, chunkEnd time.Time) string {
+	return fmt.Sprintf("range:%s:%s:%s:%d:%d", tenantID, query, res, chunkStart.UnixMilli(), chunkEnd.UnixMilli())
+}
+
+func labelSetKey(lset *profilestorepb.LabelSet) string {
+	key := ""
+	for _, l := range lset.GetLabels() {
+		key += l.GetName() + "=" + l.GetValue() + ","
+	}
+	return key
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,80 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sloghandler provides small slog.Handler wrappers shared across
+// Parca's components.
+package sloghandler
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// Dedupe wraps a slog.Handler, dropping a record that is identical (same
+// level, message and attributes) to the one immediately before it. It's the
+// slog equivalent of Prometheus' old go-kit log.Deduper, meant to keep a
+// high-volume scrape or query loop logging the same line every tick from
+// flooding the log.
+type Dedupe struct {
+	next slog.Handler
+
+	mtx  sync.Mutex
+	last string
+}
+
+// NewDedupe returns a Dedupe wrapping next.
+func NewDedupe(next slog.Handler) *Dedupe {
+	return &Dedupe{next: next}
+}
+
+// Enabled implements slog.Handler.
+func (d *Dedupe) Enabled(ctx context.Context, level slog.Level) bool {
+	return d.next.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler.
+func (d *Dedupe) Handle(ctx context.Context, r slog.Record) error {
+	key := recordKey(r)
+
+	d.mtx.Lock()
+	dup := key == d.last
+	d.last = key
+	d.mtx.Unlock()
+
+	if dup {
+		return nil
+	}
+	return d.next.Handle(ctx, r)
+}
+
+// WithAttrs implements slog.Handler.
+func (d *Dedupe) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &Dedupe{next: d.next.WithAttrs(attrs)}
+}
+
+// WithGroup implements slog.Handler.
+func (d *Dedupe) WithGroup(name string) slog.Handler {
+	return &Dedupe{next: d.next.WithGroup(name)}
+}
+
+// recordKey identifies r by level, message and attributes, so two
+// consecutive records with the same key are considered duplicates.
+func recordKey(r slog.Record) string {
+	key := r.Level.String() + "|" + r.Message
+	r.Attrs(func(a slog.Attr) bool {
+		key += "|" + a.Key + "=" + a.Value.String()
+		return true
+	})
+	return key
+}
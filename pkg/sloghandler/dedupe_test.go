@@ -0,0 +1,37 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sloghandler
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Dedupe_DropsConsecutiveDuplicates(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewDedupe(slog.NewTextHandler(&buf, nil)))
+
+	logger.Info("tick", "n", 1)
+	logger.Info("tick", "n", 1)
+	logger.Info("tick", "n", 1)
+	logger.Info("tick", "n", 2)
+	logger.Info("tick", "n", 2)
+
+	lines := strings.Count(buf.String(), "msg=tick")
+	require.Equal(t, 2, lines)
+}
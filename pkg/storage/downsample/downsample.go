@@ -0,0 +1,264 @@
+// Copyright 2021 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package downsample periodically rolls raw, per-scrape profiles up into
+// coarser time buckets, the way Thanos' compactor produces 5m/1h
+// downsampled blocks from raw Prometheus data. Downsampled series are
+// stored alongside raw series in the same storage.DB, distinguished by a
+// `__resolution__` label, so the query layer can pick whichever resolution
+// fits the request without a separate storage backend.
+package downsample
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+
+	"github.com/parca-dev/parca/pkg/storage"
+	"github.com/parca-dev/parca/pkg/storage/metastore"
+)
+
+// ResolutionLabel marks a series as the rolled-up output of a Downsampler,
+// rather than raw, per-scrape data.
+const ResolutionLabel = "__resolution__"
+
+// Resolution identifies one of the fixed rollup windows a Downsampler
+// produces.
+type Resolution string
+
+// ResolutionRaw is not produced by a Downsampler; it denotes the
+// unmodified, per-scrape series every series starts out as.
+const ResolutionRaw Resolution = ""
+
+const (
+	Resolution5m Resolution = "5m"
+	Resolution1h Resolution = "1h"
+	Resolution1d Resolution = "1d"
+)
+
+// resolutions is ordered finest to coarsest; Downsampler rolls raw series up
+// into every entry, and PickResolution walks it to find the coarsest
+// resolution that still satisfies a requested step.
+var resolutions = []struct {
+	Resolution Resolution
+	Step       time.Duration
+}{
+	{Resolution5m, 5 * time.Minute},
+	{Resolution1h, time.Hour},
+	{Resolution1d, 24 * time.Hour},
+}
+
+// PickResolution returns the coarsest resolution whose step is less than or
+// equal to step, the duration a single pixel of the requested range
+// represents. It returns ResolutionRaw if even the finest resolution is too
+// coarse.
+func PickResolution(step time.Duration) Resolution {
+	picked := ResolutionRaw
+	for _, r := range resolutions {
+		if r.Step <= step {
+			picked = r.Resolution
+		}
+	}
+	return picked
+}
+
+// Downsampler periodically merges every raw series in a storage.DB into
+// each of the fixed resolutions, writing the result back into the same DB
+// as a new series carrying ResolutionLabel.
+type Downsampler struct {
+	logger    *slog.Logger
+	db        *storage.DB
+	metaStore metastore.ProfileMetaStore
+	interval  time.Duration
+
+	mtx        sync.Mutex
+	watermarks map[watermarkKey]int64
+}
+
+// watermarkKey identifies the per-series, per-resolution high-watermark a
+// Downsampler tracks: the start of the last bucket already rolled up, so a
+// later tick never re-merges and re-appends a bucket it has already
+// produced.
+type watermarkKey struct {
+	tenant string
+	series uint64
+	res    Resolution
+}
+
+// NewDownsampler returns a Downsampler that rolls up db's raw series every
+// interval. logger may be nil, in which case a no-op logger is used.
+func NewDownsampler(logger *slog.Logger, db *storage.DB, metaStore metastore.ProfileMetaStore, interval time.Duration) *Downsampler {
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	return &Downsampler{logger: logger, db: db, metaStore: metaStore, interval: interval, watermarks: map[watermarkKey]int64{}}
+}
+
+// Run rolls up series every d.interval until ctx is canceled.
+func (d *Downsampler) Run(ctx context.Context) error {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := d.RollupOnce(ctx); err != nil {
+				d.logger.Warn("downsample rollup failed", "err", err)
+			}
+		}
+	}
+}
+
+// RollupOnce rolls every raw series in d.db up into every fixed resolution,
+// merging the samples that fall into each resolution's bucket width and
+// writing the result back as a new series tagged with ResolutionLabel.
+func (d *Downsampler) RollupOnce(ctx context.Context) error {
+	rawMatcher := labels.MustNewMatcher(labels.MatchEqual, ResolutionLabel, "")
+	for _, series := range d.db.SelectAll(rawMatcher) {
+		for _, r := range resolutions {
+			if err := d.rollupSeries(ctx, series, r.Resolution, r.Step); err != nil {
+				return fmt.Errorf("rollup series %s to %s: %w", series.Lset, r.Resolution, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (d *Downsampler) rollupSeries(ctx context.Context, series *storage.MemSeries, res Resolution, step time.Duration) error {
+	now := time.Now().UnixMilli()
+	stepMillis := step.Milliseconds()
+
+	key := watermarkKey{tenant: series.Tenant, series: series.Lset.Hash(), res: res}
+	d.mtx.Lock()
+	watermark, rolledBefore := d.watermarks[key]
+	d.mtx.Unlock()
+
+	mint := int64(0)
+	if rolledBefore {
+		// watermark is the start of the last bucket already rolled up;
+		// everything at or before it was already merged and appended, so
+		// only look past it.
+		mint = watermark + stepMillis
+	}
+
+	profiles := series.Profiles(mint, now)
+	if len(profiles) == 0 {
+		return nil
+	}
+
+	buckets := map[int64][]*storage.Profile{}
+	for _, p := range profiles {
+		bucket := (p.Meta.Timestamp / stepMillis) * stepMillis
+		// A bucket is only rolled up once it's fully closed, i.e. once its
+		// width has fully elapsed; otherwise a later tick could still see
+		// more raw samples land in it.
+		if bucket+stepMillis > now {
+			continue
+		}
+		buckets[bucket] = append(buckets[bucket], p)
+	}
+	if len(buckets) == 0 {
+		return nil
+	}
+
+	lset := append(series.Lset[:0:0], series.Lset...)
+	lset = append(lset, labels.Label{Name: ResolutionLabel, Value: string(res)})
+	sort.Sort(lset)
+
+	// Rolled-up series are written back under the same tenant they were
+	// read from: RollupOnce selects across every tenant, so the series'
+	// tenant can't be recovered from ctx here.
+	app := d.db.AppenderForTenant(series.Tenant, lset)
+
+	maxBucket := watermark
+	for bucket, ps := range buckets {
+		merged := mergeProfiles(ps, bucket)
+		if err := d.verify(merged); err != nil {
+			return fmt.Errorf("verify rolled-up profile against metastore: %w", err)
+		}
+		if err := app.Append(merged); err != nil {
+			return err
+		}
+		if bucket > maxBucket {
+			maxBucket = bucket
+		}
+	}
+
+	d.mtx.Lock()
+	d.watermarks[key] = maxBucket
+	d.mtx.Unlock()
+	return nil
+}
+
+// mergeProfiles combines every sample across ps, identified by an identical
+// stack, into a single Profile stamped with timestamp.
+func mergeProfiles(ps []*storage.Profile, timestamp int64) *storage.Profile {
+	byStack := map[string]*storage.Sample{}
+	order := make([]string, 0, len(ps))
+
+	for _, p := range ps {
+		for _, s := range p.Samples {
+			key := stackKey(s.Stack)
+			if existing, ok := byStack[key]; ok {
+				existing.Value += s.Value
+				continue
+			}
+			order = append(order, key)
+			byStack[key] = &storage.Sample{Stack: s.Stack, Value: s.Value}
+		}
+	}
+
+	merged := &storage.Profile{
+		Meta:    ps[0].Meta,
+		Samples: make([]*storage.Sample, 0, len(order)),
+	}
+	merged.Meta.Timestamp = timestamp
+	for _, key := range order {
+		merged.Samples = append(merged.Samples, byStack[key])
+	}
+	return merged
+}
+
+func stackKey(stack []string) string {
+	key := ""
+	for _, s := range stack {
+		key += s + "\x00"
+	}
+	return key
+}
+
+// verify checks the rolled-up profile's samples reference only functions
+// already known to the metastore, so a rollup can never introduce a
+// function the raw data didn't have. A Sample's Stack carries only
+// function names (see storage.Sample), so functions are looked up by name
+// rather than by the full metastore key, and locations are not checked.
+func (d *Downsampler) verify(p *storage.Profile) error {
+	for _, sample := range p.Samples {
+		for _, name := range sample.Stack {
+			if _, ok, err := d.metaStore.GetFunctionByName(name); err != nil {
+				return err
+			} else if !ok {
+				return fmt.Errorf("function %q referenced by rolled-up profile is not present in the metastore", name)
+			}
+		}
+	}
+	return nil
+}
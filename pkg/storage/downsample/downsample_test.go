@@ -0,0 +1,139 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package downsample
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/stretchr/testify/require"
+
+	"github.com/parca-dev/parca/pkg/storage"
+	"github.com/parca-dev/parca/pkg/storage/metastore"
+)
+
+// appendRaw appends a single-sample profile stamped at ts (Unix millis) to
+// db's raw "allocs" series, registering the sample's one function in s so
+// Downsampler.verify accepts the rolled-up result.
+func appendRaw(t *testing.T, ctx context.Context, db *storage.DB, s metastore.ProfileMetaStore, ts int64, value int64) {
+	t.Helper()
+
+	_, err := s.CreateFunction(&metastore.Function{Name: "main.work"})
+	require.NoError(t, err)
+
+	app, err := db.Appender(ctx, labels.Labels{
+		labels.Label{Name: "__name__", Value: "allocs"},
+	})
+	require.NoError(t, err)
+
+	err = app.Append(&storage.Profile{
+		Meta:    storage.ProfileMeta{Timestamp: ts},
+		Samples: []*storage.Sample{{Stack: []string{"main.work"}, Value: value}},
+	})
+	require.NoError(t, err)
+}
+
+// rolledUpSeries returns the single raw-to-5m rollup series appended by
+// RollupOnce, failing the test if it's missing.
+func rolledUpSeries(t *testing.T, db *storage.DB) *storage.MemSeries {
+	t.Helper()
+
+	matcher := labels.MustNewMatcher(labels.MatchEqual, ResolutionLabel, string(Resolution5m))
+	series := db.SelectAll(matcher)
+	require.Len(t, series, 1)
+	return series[0]
+}
+
+// Test_RollupOnce_Basic verifies that RollupOnce merges closed 5m buckets of
+// raw samples into a resolution-labeled series that QueryRange can later
+// pick up.
+func Test_RollupOnce_Basic(t *testing.T) {
+	ctx := context.Background()
+	db := storage.OpenDB(prometheus.NewRegistry())
+	s, err := metastore.NewInMemoryProfileMetaStore("rolluponce")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		s.Close()
+	})
+
+	bucketStart := bucketStart5m(time.Now().Add(-30 * time.Minute))
+	appendRaw(t, ctx, db, s, bucketStart, 1)
+	appendRaw(t, ctx, db, s, bucketStart+time.Minute.Milliseconds(), 2)
+
+	d := NewDownsampler(nil, db, s, time.Minute)
+	require.NoError(t, d.RollupOnce(ctx))
+
+	rolled := rolledUpSeries(t, db)
+	profiles := rolled.Profiles(0, time.Now().UnixMilli())
+	require.Len(t, profiles, 1)
+	require.Equal(t, bucketStart, profiles[0].Meta.Timestamp)
+	require.Len(t, profiles[0].Samples, 1)
+	require.Equal(t, int64(3), profiles[0].Samples[0].Value)
+}
+
+// Test_RollupOnce_IdempotentAcrossTicks verifies that a bucket already
+// rolled up on one tick isn't merged and appended again on the following
+// ticks, with no new raw samples landing in between.
+func Test_RollupOnce_IdempotentAcrossTicks(t *testing.T) {
+	ctx := context.Background()
+	db := storage.OpenDB(prometheus.NewRegistry())
+	s, err := metastore.NewInMemoryProfileMetaStore("rolluponceidempotent")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		s.Close()
+	})
+
+	bucketStart := bucketStart5m(time.Now().Add(-30 * time.Minute))
+	appendRaw(t, ctx, db, s, bucketStart, 1)
+
+	d := NewDownsampler(nil, db, s, time.Minute)
+	require.NoError(t, d.RollupOnce(ctx))
+	require.NoError(t, d.RollupOnce(ctx))
+	require.NoError(t, d.RollupOnce(ctx))
+
+	rolled := rolledUpSeries(t, db)
+	profiles := rolled.Profiles(0, time.Now().UnixMilli())
+	require.Len(t, profiles, 1, "repeated ticks must not duplicate an already rolled-up bucket")
+}
+
+// Test_RollupOnce_SkipsOpenBucket verifies that a bucket still within the
+// current resolution window is left for a later tick rather than rolled up
+// early.
+func Test_RollupOnce_SkipsOpenBucket(t *testing.T) {
+	ctx := context.Background()
+	db := storage.OpenDB(prometheus.NewRegistry())
+	s, err := metastore.NewInMemoryProfileMetaStore("rolluponceopenbucket")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		s.Close()
+	})
+
+	appendRaw(t, ctx, db, s, time.Now().UnixMilli(), 1)
+
+	d := NewDownsampler(nil, db, s, time.Minute)
+	require.NoError(t, d.RollupOnce(ctx))
+
+	matcher := labels.MustNewMatcher(labels.MatchEqual, ResolutionLabel, string(Resolution5m))
+	require.Empty(t, db.SelectAll(matcher))
+}
+
+// bucketStart5m floors t onto the start of the 5m bucket it falls into, in
+// Unix millis, matching rollupSeries' own bucketing.
+func bucketStart5m(t time.Time) int64 {
+	stepMillis := (5 * time.Minute).Milliseconds()
+	return (t.UnixMilli() / stepMillis) * stepMillis
+}
@@ -0,0 +1,184 @@
+// Copyright 2021 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metastore stores the metadata that profile samples reference
+// (locations, functions, mappings) out-of-line from the sample values
+// themselves, so that identical stack frames across many profiles and
+// series are only ever stored once.
+package metastore
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Location is a single stack frame, analogous to a pprof Location.
+type Location struct {
+	ID        uint64
+	Address   uint64
+	MappingID uint64
+	Lines     []Line
+}
+
+// Line associates a location with a function and line number.
+type Line struct {
+	FunctionID uint64
+	Line       int64
+}
+
+// Function is a single function, analogous to a pprof Function.
+type Function struct {
+	ID         uint64
+	Name       string
+	SystemName string
+	Filename   string
+}
+
+// Mapping is a single binary/library mapping, analogous to a pprof Mapping.
+type Mapping struct {
+	ID      uint64
+	Start   uint64
+	Limit   uint64
+	Offset  uint64
+	File    string
+	BuildID string
+}
+
+// ProfileMetaStore persists the metadata referenced by profile samples so
+// that it can be looked up and deduplicated across profiles.
+type ProfileMetaStore interface {
+	GetLocationByKey(l Location) (*Location, bool, error)
+	CreateLocation(l *Location) (uint64, error)
+	GetFunctionByKey(f Function) (*Function, bool, error)
+	// GetFunctionByName looks up a function by name alone, for callers that
+	// only ever have a bare function name to go on (for example a
+	// storage.Sample's stack, which is flattened to names and carries no
+	// filename).
+	GetFunctionByName(name string) (*Function, bool, error)
+	CreateFunction(f *Function) (uint64, error)
+	GetMappingByKey(m Mapping) (*Mapping, bool, error)
+	CreateMapping(m *Mapping) (uint64, error)
+	Close() error
+}
+
+// InMemoryProfileMetaStore is a ProfileMetaStore backed by in-memory maps.
+// It's primarily used in tests and for small, single-process deployments.
+type InMemoryProfileMetaStore struct {
+	name string
+
+	mtx          sync.RWMutex
+	locations    map[uint64]*Location
+	functions    map[uint64]*Function
+	mappings     map[uint64]*Mapping
+	nextLocation uint64
+	nextFunction uint64
+	nextMapping  uint64
+}
+
+// NewInMemoryProfileMetaStore returns a new InMemoryProfileMetaStore. name
+// is used to distinguish metastores in tests and metrics and otherwise has
+// no effect on behavior.
+func NewInMemoryProfileMetaStore(name string) (*InMemoryProfileMetaStore, error) {
+	if name == "" {
+		return nil, fmt.Errorf("metastore name must not be empty")
+	}
+
+	return &InMemoryProfileMetaStore{
+		name:      name,
+		locations: map[uint64]*Location{},
+		functions: map[uint64]*Function{},
+		mappings:  map[uint64]*Mapping{},
+	}, nil
+}
+
+func (s *InMemoryProfileMetaStore) GetLocationByKey(l Location) (*Location, bool, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	for _, loc := range s.locations {
+		if loc.Address == l.Address && loc.MappingID == l.MappingID {
+			return loc, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+func (s *InMemoryProfileMetaStore) CreateLocation(l *Location) (uint64, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.nextLocation++
+	l.ID = s.nextLocation
+	s.locations[l.ID] = l
+	return l.ID, nil
+}
+
+func (s *InMemoryProfileMetaStore) GetFunctionByKey(f Function) (*Function, bool, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	for _, fn := range s.functions {
+		if fn.Name == f.Name && fn.Filename == f.Filename {
+			return fn, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+func (s *InMemoryProfileMetaStore) GetFunctionByName(name string) (*Function, bool, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	for _, fn := range s.functions {
+		if fn.Name == name {
+			return fn, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+func (s *InMemoryProfileMetaStore) CreateFunction(f *Function) (uint64, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.nextFunction++
+	f.ID = s.nextFunction
+	s.functions[f.ID] = f
+	return f.ID, nil
+}
+
+func (s *InMemoryProfileMetaStore) GetMappingByKey(m Mapping) (*Mapping, bool, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	for _, mp := range s.mappings {
+		if mp.BuildID == m.BuildID && mp.Start == m.Start {
+			return mp, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+func (s *InMemoryProfileMetaStore) CreateMapping(m *Mapping) (uint64, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.nextMapping++
+	m.ID = s.nextMapping
+	s.mappings[m.ID] = m
+	return m.ID, nil
+}
+
+func (s *InMemoryProfileMetaStore) Close() error {
+	return nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2021 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"io"
+	"log/slog"
+
+	"github.com/google/pprof/profile"
+
+	"github.com/parca-dev/parca/pkg/storage/metastore"
+)
+
+// ProfileMeta carries the metadata of a single stored profile: when it was
+// taken, over what duration, and what kind of profile it is.
+type ProfileMeta struct {
+	Name       string
+	PeriodType string
+	SampleType string
+	Timestamp  int64
+	Duration   int64
+	Period     int64
+}
+
+// Sample is a single pprof sample flattened to a stack of function names
+// (ordered leaf-first, matching pprof's Sample.Location order) and the
+// value recorded for the requested sample index.
+type Sample struct {
+	Stack []string
+	Value int64
+}
+
+// Profile is Parca's in-memory representation of a single pprof profile,
+// ready to be appended to a series or merged with other Profiles.
+type Profile struct {
+	Meta    ProfileMeta
+	Samples []*Sample
+}
+
+// ProfileFromPprof converts a parsed pprof profile into Parca's internal
+// Profile representation, registering every location and function
+// referenced by the profile's samples in the given metastore so that
+// identical stack frames are deduplicated across profiles.
+func ProfileFromPprof(logger *slog.Logger, s metastore.ProfileMetaStore, p *profile.Profile, sampleIndex int) *Profile {
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	meta := ProfileMeta{Timestamp: p.TimeNanos / 1000000, Duration: p.DurationNanos}
+	if p.PeriodType != nil {
+		meta.PeriodType = p.PeriodType.Type
+		meta.Period = p.Period
+	}
+	if sampleIndex >= 0 && sampleIndex < len(p.SampleType) {
+		meta.SampleType = p.SampleType[sampleIndex].Type
+	}
+
+	samples := make([]*Sample, 0, len(p.Sample))
+	for _, sample := range p.Sample {
+		if sampleIndex < 0 || sampleIndex >= len(sample.Value) {
+			continue
+		}
+
+		stack := make([]string, 0, len(sample.Location))
+		for _, loc := range sample.Location {
+			fn, name := functionFor(loc)
+			if fn != nil {
+				if _, ok, err := s.GetFunctionByKey(*fn); err == nil && !ok {
+					if _, err := s.CreateFunction(fn); err != nil {
+						logger.Debug("failed to persist function in metastore", "err", err)
+					}
+				}
+			}
+			if _, ok, err := s.GetLocationByKey(metastore.Location{Address: loc.Address}); err == nil && !ok {
+				if _, err := s.CreateLocation(&metastore.Location{Address: loc.Address}); err != nil {
+					logger.Debug("failed to persist location in metastore", "err", err)
+				}
+			}
+			stack = append(stack, name)
+		}
+
+		samples = append(samples, &Sample{Stack: stack, Value: sample.Value[sampleIndex]})
+	}
+
+	return &Profile{Meta: meta, Samples: samples}
+}
+
+func functionFor(loc *profile.Location) (*metastore.Function, string) {
+	if len(loc.Line) == 0 || loc.Line[0].Function == nil {
+		return nil, "unknown"
+	}
+	f := loc.Line[0].Function
+	return &metastore.Function{Name: f.Name, SystemName: f.SystemName, Filename: f.Filename}, f.Name
+}
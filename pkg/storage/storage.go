@@ -0,0 +1,201 @@
+// Copyright 2021 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package storage holds the append-only, in-memory profile store that
+// backs Parca's query layer. Profiles are appended per label set ("series",
+// in the same sense Prometheus uses the term) and later selected by
+// matchers and a time range.
+package storage
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/pkg/labels"
+
+	"github.com/parca-dev/parca/pkg/tenant"
+)
+
+// Appender appends a single Profile to the series it was opened for.
+type Appender interface {
+	Append(p *Profile) error
+}
+
+// MemSeries is a single label set's ordered, in-memory history of profiles.
+type MemSeries struct {
+	Tenant string
+	Lset   labels.Labels
+
+	mtx      sync.RWMutex
+	profiles []*Profile
+}
+
+func (s *MemSeries) appender(appendsTotal prometheus.Counter) Appender {
+	return &memSeriesAppender{series: s, appendsTotal: appendsTotal}
+}
+
+type memSeriesAppender struct {
+	series       *MemSeries
+	appendsTotal prometheus.Counter
+}
+
+func (a *memSeriesAppender) Append(p *Profile) error {
+	a.series.mtx.Lock()
+	defer a.series.mtx.Unlock()
+	a.appendsTotal.Inc()
+
+	// Idempotent per timestamp: a profile appended at a timestamp that's
+	// already present (e.g. a downsample bucket re-appended by a retried
+	// or overlapping rollup) replaces the existing one instead of adding a
+	// duplicate point.
+	for i, existing := range a.series.profiles {
+		if existing.Meta.Timestamp == p.Meta.Timestamp {
+			a.series.profiles[i] = p
+			return nil
+		}
+	}
+
+	a.series.profiles = append(a.series.profiles, p)
+	sort.Slice(a.series.profiles, func(i, j int) bool {
+		return a.series.profiles[i].Meta.Timestamp < a.series.profiles[j].Meta.Timestamp
+	})
+	return nil
+}
+
+// Profiles returns the series' profiles with a timestamp in [mint, maxt],
+// in ascending timestamp order. mint/maxt are Unix milliseconds.
+func (s *MemSeries) Profiles(mint, maxt int64) []*Profile {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	res := make([]*Profile, 0, len(s.profiles))
+	for _, p := range s.profiles {
+		if p.Meta.Timestamp >= mint && p.Meta.Timestamp <= maxt {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// DB is an in-memory, per-process store of profile series, analogous in
+// spirit to a Prometheus TSDB head block. Series are partitioned by tenant:
+// two tenants appending an identical label set get two distinct series, and
+// neither can select the other's.
+type DB struct {
+	mtx    sync.RWMutex
+	series map[string]*MemSeries
+
+	appendsTotal prometheus.Counter
+}
+
+// OpenDB returns a ready to use, empty DB. Metrics describing the DB are
+// registered against reg, which may be nil.
+func OpenDB(reg prometheus.Registerer) *DB {
+	db := &DB{
+		series: map[string]*MemSeries{},
+	}
+
+	db.appendsTotal = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "parca_storage_appends_total",
+		Help: "Number of profiles appended across all series.",
+	})
+	promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "parca_storage_series",
+		Help: "Number of series currently held in memory, across all tenants.",
+	}, func() float64 {
+		db.mtx.RLock()
+		defer db.mtx.RUnlock()
+		return float64(len(db.series))
+	})
+
+	return db
+}
+
+// Appender returns an Appender for the series identified by lset under the
+// tenant ctx is scoped to (see the tenant package), creating the series if
+// it doesn't exist yet.
+func (db *DB) Appender(ctx context.Context, lset labels.Labels) (Appender, error) {
+	return db.AppenderForTenant(tenant.IDFromContext(ctx), lset), nil
+}
+
+// AppenderForTenant is like Appender, but takes the tenant ID directly
+// rather than resolving it from ctx. It's meant for background jobs, such
+// as the downsampler, that write on behalf of a series they read rather
+// than a single incoming request.
+func (db *DB) AppenderForTenant(tenantID string, lset labels.Labels) Appender {
+	sorted := append(lset[:0:0], lset...)
+	sort.Sort(sorted)
+	key := seriesKey(tenantID, sorted)
+
+	db.mtx.Lock()
+	s, ok := db.series[key]
+	if !ok {
+		s = &MemSeries{Tenant: tenantID, Lset: sorted}
+		db.series[key] = s
+	}
+	db.mtx.Unlock()
+
+	return s.appender(db.appendsTotal)
+}
+
+// Select returns every series scoped to ctx's tenant whose label set
+// matches every given matcher.
+func (db *DB) Select(ctx context.Context, matchers ...*labels.Matcher) []*MemSeries {
+	tenantID := tenant.IDFromContext(ctx)
+
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+
+	res := make([]*MemSeries, 0, len(db.series))
+	for _, s := range db.series {
+		if s.Tenant == tenantID && matches(s.Lset, matchers) {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// SelectAll returns every series matching matchers, across every tenant.
+// It's meant for background jobs, such as the downsampler, that must see
+// the whole DB rather than a single tenant's view of it.
+func (db *DB) SelectAll(matchers ...*labels.Matcher) []*MemSeries {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+
+	res := make([]*MemSeries, 0, len(db.series))
+	for _, s := range db.series {
+		if matches(s.Lset, matchers) {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// seriesKey identifies a series by tenant and label set, so two tenants
+// appending an identical label set land in distinct series.
+func seriesKey(tenantID string, lset labels.Labels) string {
+	return tenantID + "\xff" + strconv.FormatUint(lset.Hash(), 16)
+}
+
+func matches(lset labels.Labels, matchers []*labels.Matcher) bool {
+	for _, m := range matchers {
+		if !m.Matches(lset.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tenant
+
+import "sync"
+
+// Limits bounds how much work a single tenant's queries may do. A zero
+// value field means that dimension is unlimited.
+type Limits struct {
+	// MaxSeries caps the number of series a single QueryRange may return.
+	MaxSeries int
+	// MaxSamplesScanned caps the number of pprof samples a single Query may
+	// scan across the profiles it merges or diffs.
+	MaxSamplesScanned int
+	// MaxConcurrentQueries caps how many Query/QueryRange calls a tenant may
+	// have in flight at once.
+	MaxConcurrentQueries int
+}
+
+// LimitsProvider resolves the Limits that apply to a tenant.
+type LimitsProvider interface {
+	LimitsFor(tenantID string) Limits
+}
+
+// StaticLimits is a LimitsProvider backed by a fixed configuration: every
+// tenant gets Default, except those with an entry in PerTenant.
+type StaticLimits struct {
+	Default   Limits
+	PerTenant map[string]Limits
+}
+
+// LimitsFor implements LimitsProvider.
+func (s StaticLimits) LimitsFor(tenantID string) Limits {
+	if l, ok := s.PerTenant[tenantID]; ok {
+		return l
+	}
+	return s.Default
+}
+
+// Limiter enforces Limits.MaxConcurrentQueries per tenant.
+type Limiter struct {
+	mtx  sync.Mutex
+	sems map[string]chan struct{}
+}
+
+// NewLimiter returns a ready to use Limiter.
+func NewLimiter() *Limiter {
+	return &Limiter{sems: map[string]chan struct{}{}}
+}
+
+// Acquire reserves a concurrency slot for tenantID out of max. max <= 0
+// means unlimited: Acquire always succeeds and release is a no-op. When the
+// tenant is already at max, ok is false and release is nil.
+func (l *Limiter) Acquire(tenantID string, max int) (release func(), ok bool) {
+	if max <= 0 {
+		return func() {}, true
+	}
+
+	l.mtx.Lock()
+	sem, exists := l.sems[tenantID]
+	if !exists {
+		sem = make(chan struct{}, max)
+		l.sems[tenantID] = sem
+	}
+	l.mtx.Unlock()
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, true
+	default:
+		return nil, false
+	}
+}
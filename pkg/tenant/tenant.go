@@ -0,0 +1,63 @@
+// Copyright 2022 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tenant resolves the tenant a request is scoped to, following the
+// single gRPC metadata header Cortex, Mimir and M3 all use for the same
+// purpose.
+package tenant
+
+import (
+	"context"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// OrgIDHeader is the gRPC metadata key a client sets to scope a request to a
+// tenant.
+const OrgIDHeader = "X-Scope-OrgID"
+
+// DefaultTenantID is used when a request carries no OrgIDHeader, so a
+// single-tenant deployment (and every existing direct, non-gRPC call in
+// tests) behaves exactly as it did before tenancy existed.
+const DefaultTenantID = "anonymous"
+
+// Resolver resolves the tenant ID a request should be scoped to.
+type Resolver interface {
+	TenantID(ctx context.Context) (string, error)
+}
+
+// NewResolver returns a Resolver reading OrgIDHeader off ctx's incoming gRPC
+// metadata.
+func NewResolver() Resolver {
+	return headerResolver{}
+}
+
+type headerResolver struct{}
+
+func (headerResolver) TenantID(ctx context.Context) (string, error) {
+	return IDFromContext(ctx), nil
+}
+
+// IDFromContext extracts the tenant ID from ctx's incoming gRPC metadata,
+// falling back to DefaultTenantID if OrgIDHeader isn't set.
+func IDFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return DefaultTenantID
+	}
+	vals := md.Get(OrgIDHeader)
+	if len(vals) == 0 || vals[0] == "" {
+		return DefaultTenantID
+	}
+	return vals[0]
+}